@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	accessLogTimeLocal = "02/Jan/2006:15:04:05 -0700"
+)
+
+// built-in access log formats, in nginx log_format syntax
+var accessLogFormats = map[string]string{
+	"common":   `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent`,
+	"combined": `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`,
+	"nginx":    `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" "$http_x_forwarded_for"`,
+}
+
+// compileAccessLogFormat convert a nginx log_format string to a regular expression, variables are named captures
+// matching until the following literal character
+func compileAccessLogFormat(format string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(format); {
+		// literal text
+		if format[i] != '$' {
+			j := strings.IndexByte(format[i:], '$')
+			if j < 0 {
+				j = len(format) - i
+			}
+			sb.WriteString(regexp.QuoteMeta(format[i : i+j]))
+			i += j
+			continue
+		}
+		// variable, $name or ${name}
+		i++
+		braced := i < len(format) && format[i] == '{'
+		if braced {
+			i++
+		}
+		j := i
+		for j < len(format) && (format[j] == '_' || format[j] >= 'a' && format[j] <= 'z' || format[j] >= 'A' && format[j] <= 'Z' || format[j] >= '0' && format[j] <= '9') {
+			j++
+		}
+		name := format[i:j]
+		if len(name) == 0 {
+			return nil, errors.New("bad variable in log format at " + strconv.Itoa(i))
+		}
+		if braced {
+			if j >= len(format) || format[j] != '}' {
+				return nil, errors.New("unclosed variable '" + name + "' in log format")
+			}
+			j++
+		}
+		i = j
+		// capture until the next literal character
+		capture := `\S*`
+		if i < len(format) {
+			if format[i] == '$' {
+				capture = `.*?`
+			} else {
+				capture = `[^` + regexp.QuoteMeta(format[i:i+1]) + `]*`
+			}
+		}
+		sb.WriteString("(?P<" + name + ">" + capture + ")")
+	}
+	return regexp.Compile(sb.String())
+}
+
+// compile compile the format of access log options
+func (a *AccessLogOptions) compile() (err error) {
+	if _, err = path.Match(a.Topic, ""); err != nil {
+		return
+	}
+	if _, err = path.Match(a.Project, ""); err != nil {
+		return
+	}
+	format := a.Format
+	if builtin, ok := accessLogFormats[format]; ok {
+		format = builtin
+	}
+	if len(format) == 0 {
+		return errors.New("no format for access log")
+	}
+	a.regexp, err = compileAccessLogFormat(format)
+	return
+}
+
+// Decode parse an access log line into typed extra fields, t is the parsed time of request if present
+func (a AccessLogOptions) Decode(line string, r *Record) (t time.Time, ok bool) {
+	if a.regexp == nil {
+		return
+	}
+	m := a.regexp.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	ok = true
+	copyExtra(r)
+	for i, name := range a.regexp.SubexpNames() {
+		v := m[i]
+		if len(name) == 0 || len(v) == 0 || v == "-" {
+			continue
+		}
+		switch name {
+		case "remote_addr":
+			r.Extra["client_ip"] = v
+		case "remote_user":
+			r.Extra["user"] = v
+		case "time_local", "time_iso8601":
+			layout := accessLogTimeLocal
+			if name == "time_iso8601" {
+				layout = time.RFC3339
+			}
+			if pt, err := time.Parse(layout, v); err == nil {
+				t = pt
+				r.Extra["time"] = pt
+			}
+		case "request":
+			fs := strings.Fields(v)
+			if len(fs) > 0 {
+				r.Extra["method"] = fs[0]
+			}
+			if len(fs) > 1 {
+				r.Extra["path"] = fs[1]
+			}
+			if len(fs) > 2 {
+				r.Extra["protocol"] = fs[2]
+			}
+		case "request_method":
+			r.Extra["method"] = v
+		case "request_uri", "uri":
+			r.Extra["path"] = v
+		case "server_protocol":
+			r.Extra["protocol"] = v
+		case "status", "body_bytes_sent", "bytes_sent", "request_length":
+			if name == "body_bytes_sent" || name == "bytes_sent" {
+				name = "bytes"
+			}
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				r.Extra[name] = n
+			}
+		case "http_referer":
+			r.Extra["referrer"] = v
+		case "http_user_agent":
+			r.Extra["user_agent"] = v
+		case "request_time", "upstream_response_time", "upstream_connect_time", "upstream_header_time":
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				r.Extra[name] = f
+			} else {
+				r.Extra[name] = v
+			}
+		default:
+			r.Extra[name] = v
+		}
+	}
+	return
+}
+
+// resolveAccessLog find the first access log options matching topic and project
+func resolveAccessLog(topic, project string) (a AccessLogOptions, ok bool) {
+	for _, a = range options.AccessLogs {
+		if matchTopicProject(a.Topic, a.Project, topic, project) {
+			ok = true
+			return
+		}
+	}
+	a = AccessLogOptions{}
+	return
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const testCombinedLine = `10.0.0.1 - bob [20/Jul/2018:15:03:00 +0800] "GET /api/v1/users?id=1 HTTP/1.1" 200 512 "https://example.com/" "Mozilla/5.0 (X11; Linux x86_64)"`
+
+func TestAccessLogOptions_Decode(t *testing.T) {
+	a := AccessLogOptions{Format: "combined"}
+	if err := a.compile(); err != nil {
+		t.Fatal(err)
+	}
+	var r Record
+	ts, ok := a.Decode(testCombinedLine, &r)
+	if !ok {
+		t.Fatal("failed")
+	}
+	if !ts.Equal(time.Date(2018, time.July, 20, 7, 3, 0, 0, time.UTC)) {
+		t.Fatal("time", ts)
+	}
+	if r.Extra["client_ip"] != "10.0.0.1" || r.Extra["user"] != "bob" || r.Extra["method"] != "GET" ||
+		r.Extra["path"] != "/api/v1/users?id=1" || r.Extra["protocol"] != "HTTP/1.1" ||
+		r.Extra["status"] != int64(200) || r.Extra["bytes"] != int64(512) ||
+		r.Extra["referrer"] != "https://example.com/" || r.Extra["user_agent"] != "Mozilla/5.0 (X11; Linux x86_64)" {
+		t.Fatal("extra", r.Extra)
+	}
+	// common format accepts combined lines
+	a = AccessLogOptions{Format: "common"}
+	if err := a.compile(); err != nil {
+		t.Fatal(err)
+	}
+	r = Record{}
+	if _, ok = a.Decode(testCombinedLine, &r); !ok || r.Extra["bytes"] != int64(512) || r.Extra["user_agent"] != nil {
+		t.Fatal("common", r.Extra)
+	}
+	if _, ok = a.Decode("not an access log", &r); ok {
+		t.Fatal("should not match")
+	}
+}
+
+func TestAccessLogOptions_Custom(t *testing.T) {
+	a := AccessLogOptions{Format: `$remote_addr "$request_method ${request_uri}" $status $request_time $upstream_response_time "$http_x_trace"`}
+	if err := a.compile(); err != nil {
+		t.Fatal(err)
+	}
+	var r Record
+	ts, ok := a.Decode(`10.0.0.2 "POST /login" 502 0.125 - "abc"`, &r)
+	if !ok || !ts.IsZero() {
+		t.Fatal("failed", ts)
+	}
+	if r.Extra["method"] != "POST" || r.Extra["path"] != "/login" || r.Extra["status"] != int64(502) ||
+		r.Extra["request_time"] != 0.125 || r.Extra["upstream_response_time"] != nil || r.Extra["http_x_trace"] != "abc" {
+		t.Fatal("extra", r.Extra)
+	}
+	for _, format := range []string{"", "$ bad", "${unclosed"} {
+		if err := (&AccessLogOptions{Format: format}).compile(); err == nil {
+			t.Fatal("should fail", format)
+		}
+	}
+}
+
+func TestEvent_ToRecord_AccessLog(t *testing.T) {
+	options = Options{AccessLogs: []AccessLogOptions{{Topic: "access", Format: "nginx", UseTime: true}}}
+	defer func() {
+		options = Options{}
+	}()
+	if err := options.AccessLogs[0].compile(); err != nil {
+		t.Fatal(err)
+	}
+	// without timestamp prefix
+	be := Event{Source: "/tmp/test2/access/test1.log", Message: testCombinedLine + ` "-"`}
+	r, _, ok := be.ToRecord(-8)
+	if !ok {
+		t.Fatal("failed")
+	}
+	if !r.Timestamp.Equal(time.Date(2018, time.July, 20, 7, 3, 0, 0, time.UTC)) {
+		t.Fatal("timestamp", r.Timestamp)
+	}
+	if r.Extra["status"] != int64(200) || r.Message != testCombinedLine+` "-"` {
+		t.Fatal("extra", r.Extra, r.Message)
+	}
+	// with timestamp prefix, time of request is preferred
+	be.Message = "[2018/07/21 15:03:00.000] " + testCombinedLine + ` "-"`
+	if r, _, ok = be.ToRecord(-8); !ok || r.Timestamp.Day() != 20 {
+		t.Fatal("prefixed", r.Timestamp)
+	}
+	// not an access log
+	be.Message = "garbage"
+	if _, reason, ok := be.ToRecord(-8); ok || reason != ReasonBadTimestamp {
+		t.Fatal("garbage")
+	}
+}
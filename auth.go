@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/subtle"
+	"strings"
+)
+
+const (
+	defaultUser = "default"
+)
+
+// Session state of a redis protocol connection
+type Session struct {
+	// Tenant authenticated user or CN of client certificate, empty if not authenticated
+	Tenant string
+	// peerChecked client certificate has been checked
+	peerChecked bool
+}
+
+func sessionOf(ctx interface{}) *Session {
+	if s, ok := ctx.(*Session); ok {
+		return s
+	}
+	return &Session{}
+}
+
+// authRequired check if credentials are configured
+func authRequired() bool {
+	return len(options.Auth.Password) > 0 || len(options.Auth.Users) > 0
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// sanitizeTenant replace characters not allowed in elasticsearch index names with '_', leading '_', '-' and '.' are
+// removed, tenants are used as index prefix
+func sanitizeTenant(name string) string {
+	buf := []byte(name)
+	for i, c := range buf {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-') {
+			buf[i] = '_'
+		}
+	}
+	return strings.TrimLeft(string(buf), "_-.")
+}
+
+// isValidTenant check name can be used as tenant without sanitizing
+func isValidTenant(name string) bool {
+	return len(name) > 0 && sanitizeTenant(name) == name
+}
+
+// authenticate check credentials, empty user means the legacy single-password form, returns the tenant
+func authenticate(user, pass string) (tenant string, ok bool) {
+	if len(user) == 0 {
+		user = defaultUser
+		if len(options.Auth.Password) > 0 {
+			if secureCompare(options.Auth.Password, pass) {
+				return user, true
+			}
+			return
+		}
+	}
+	if expected, found := options.Auth.Users[user]; found && secureCompare(expected, pass) {
+		return user, true
+	}
+	return
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/yankeguo/redcon"
+)
+
+func TestAuthenticate(t *testing.T) {
+	defer func() {
+		options = Options{}
+	}()
+	if authRequired() {
+		t.Fatal("not required")
+	}
+	options.Auth = AuthOptions{Password: "secret", Users: map[string]string{"team-a": "pass-a"}}
+	if !authRequired() {
+		t.Fatal("required")
+	}
+	if tenant, ok := authenticate("", "secret"); !ok || tenant != defaultUser {
+		t.Fatal("legacy form")
+	}
+	if _, ok := authenticate("", "pass-a"); ok {
+		t.Fatal("legacy form with wrong password")
+	}
+	if tenant, ok := authenticate("team-a", "pass-a"); !ok || tenant != "team-a" {
+		t.Fatal("acl form")
+	}
+	if _, ok := authenticate("team-b", "pass-a"); ok {
+		t.Fatal("unknown user")
+	}
+	// legacy form falls back to user 'default'
+	options.Auth = AuthOptions{Users: map[string]string{defaultUser: "secret"}}
+	if tenant, ok := authenticate("", "secret"); !ok || tenant != defaultUser {
+		t.Fatal("legacy form with default user")
+	}
+}
+
+func TestSanitizeTenant(t *testing.T) {
+	for cn, expected := range map[string]string{
+		"team-a":            "team-a",
+		"Team A/ops,*":      "Team_A_ops__",
+		"_internal.service": "internal.service",
+		"***":               "",
+	} {
+		if tenant := sanitizeTenant(cn); tenant != expected {
+			t.Fatal("sanitize", cn, tenant)
+		}
+	}
+	if isValidTenant("team a") || isValidTenant("") || !isValidTenant("team.a") {
+		t.Fatal("valid")
+	}
+}
+
+func TestCommandHandlerFunc_Auth(t *testing.T) {
+	q := &memoryQueue{}
+	outputs = map[string]*Output{defaultOutput: {Name: defaultOutput, Queue: q}}
+	options = Options{Auth: AuthOptions{Password: "secret", Users: map[string]string{"team-a": "pass-a"}, TenantIndex: true}}
+	defer func() {
+		options = Options{}
+		outputs = map[string]*Output{}
+	}()
+	s := redcon.NewServer("127.0.0.1:0", commandHandlerFunc, acceptHandlerFunc, closedHandlerFunc)
+	setup := make(chan error, 1)
+	go s.ListenServeAndSignal(setup)
+	if err := <-setup; err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newRESPClient(conn)
+	defer c.Close()
+	event := `{"message":"[2018/07/20 15:03:00.000] hello","source":"/var/log/test/err/project.log"}`
+
+	// commands except PING, QUIT and AUTH require authentication
+	if v, err := c.Do("PING"); err != nil || v != "PONG" {
+		t.Fatal("ping", v, err)
+	}
+	if v, err := c.Do("RPUSH", "xlog", event); err != nil || !strings.HasPrefix(fmt.Sprint(v), "NOAUTH") {
+		t.Fatal("noauth", v, err)
+	}
+	if v, err := c.Do("AUTH", "team-a", "bad"); err != nil || !strings.HasPrefix(fmt.Sprint(v), "WRONGPASS") {
+		t.Fatal("wrong password", v, err)
+	}
+	if v, err := c.Do("AUTH", "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	} else if _, ok := v.(respError); !ok {
+		t.Fatal("wrong number of arguments", v)
+	}
+	// ACL form authenticates as tenant
+	if v, err := c.Do("AUTH", "team-a", "pass-a"); err != nil || v != "OK" {
+		t.Fatal("auth", v, err)
+	}
+	if v, err := c.Do("RPUSH", "xlog", event); err != nil || v != int64(1) {
+		t.Fatal("rpush", v, err)
+	}
+	o, err := DecodeOperation(q.items[0])
+	if err != nil || !strings.HasPrefix(o.Index, "team-a-") || !strings.Contains(string(o.Body), `"tenant":"team-a"`) {
+		t.Fatal("tenant", o.Index, string(o.Body), err)
+	}
+	// legacy form authenticates as default user
+	if v, err := c.Do("AUTH", "secret"); err != nil || v != "OK" {
+		t.Fatal("legacy auth", v, err)
+	}
+	if v, err := c.Do("RPUSH", "xlog", event); err != nil || v != int64(2) {
+		t.Fatal("rpush", v, err)
+	}
+	if o, err = DecodeOperation(q.items[1]); err != nil || !strings.HasPrefix(o.Index, defaultUser+"-") {
+		t.Fatal("default tenant", o.Index, err)
+	}
+}
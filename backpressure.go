@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	throttled      int32
+	throttleEvents int64
+)
+
+// isThrottled check if queues are above the high-water mark
+func isThrottled() bool {
+	return atomic.LoadInt32(&throttled) == 1
+}
+
+// waitUnthrottled block until queues are below the low-water mark or stop is closed
+func waitUnthrottled(stop chan struct{}) {
+	for isThrottled() {
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Millisecond * 200):
+		}
+	}
+}
+
+// queueBytes total size of disk queue files in data dir
+func queueBytes() (size int64) {
+	names, _ := filepath.Glob(filepath.Join(options.DataDir, "xlogd*.diskqueue.*.dat"))
+	for _, name := range names {
+		if info, err := os.Stat(name); err == nil {
+			size += info.Size()
+		}
+	}
+	return
+}
+
+// updateBackpressure update throttled state with depth and bytes of queues, returns true if state changed
+func updateBackpressure(opts BackpressureOptions, depth int64, size int64) bool {
+	if isThrottled() {
+		// resume if all marks are below low-water marks
+		if (opts.HighDepth <= 0 || depth < opts.LowDepth) && (opts.HighBytes <= 0 || size < opts.LowBytes) {
+			atomic.StoreInt32(&throttled, 0)
+			return true
+		}
+	} else {
+		// throttle if any mark is above high-water marks
+		if (opts.HighDepth > 0 && depth >= opts.HighDepth) || (opts.HighBytes > 0 && size >= opts.HighBytes) {
+			atomic.StoreInt32(&throttled, 1)
+			atomic.AddInt64(&throttleEvents, 1)
+			return true
+		}
+	}
+	return false
+}
+
+func backpressureRoutine() {
+	if options.Backpressure.HighDepth <= 0 && options.Backpressure.HighBytes <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		depth, size := totalDepth(), queueBytes()
+		if updateBackpressure(options.Backpressure, depth, size) {
+			if isThrottled() {
+				log.Warn().Int64("depth", depth).Int64("bytes", size).Msg("queue above high-water mark, pushes refused")
+			} else {
+				log.Info().Int64("depth", depth).Int64("bytes", size).Msg("queue below low-water mark, pushes resumed")
+			}
+		}
+	}
+}
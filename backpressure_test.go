@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestUpdateBackpressure(t *testing.T) {
+	defer func() {
+		throttled, throttleEvents = 0, 0
+	}()
+	opts := BackpressureOptions{HighDepth: 100, LowDepth: 80, HighBytes: 1000, LowBytes: 800}
+	if updateBackpressure(opts, 99, 999) || isThrottled() {
+		t.Fatal("below high")
+	}
+	if !updateBackpressure(opts, 10, 1000) || !isThrottled() {
+		t.Fatal("bytes above high")
+	}
+	if updateBackpressure(opts, 10, 900) || !isThrottled() {
+		t.Fatal("bytes above low")
+	}
+	if updateBackpressure(opts, 90, 10) || !isThrottled() {
+		t.Fatal("depth above low")
+	}
+	if !updateBackpressure(opts, 79, 799) || isThrottled() {
+		t.Fatal("below low")
+	}
+	if throttleEvents != 1 {
+		t.Fatal("events", throttleEvents)
+	}
+	// bytes mark disabled
+	opts = BackpressureOptions{HighDepth: 100, LowDepth: 80}
+	if !updateBackpressure(opts, 100, 1<<40) || !updateBackpressure(opts, 0, 1<<40) || isThrottled() {
+		t.Fatal("depth only")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	deadLetters *Journal
+
+	deadCounts      = map[string]int64{}
+	deadCountsMutex = &sync.Mutex{}
+
+	reinjectMutex = &sync.Mutex{}
+)
+
+func saveDeadLetter(d DeadLetter) (err error) {
+	deadCountsMutex.Lock()
+	deadCounts[d.Reason] = deadCounts[d.Reason] + 1
+	deadCountsMutex.Unlock()
+	log.Debug().Str("addr", d.Addr).Str("key", d.Key).Str("reason", d.Reason).Int("raw-length", len(d.Raw)).Msg("dead letter")
+	d.Timestamp = time.Now()
+	if err = deadLetters.Append(d); err != nil {
+		log.Error().Err(err).Str("reason", d.Reason).Msg("failed to save dead letter")
+	}
+	return
+}
+
+func deadLetterCounts() (total int64, counts map[string]int64) {
+	deadCountsMutex.Lock()
+	defer deadCountsMutex.Unlock()
+	counts = map[string]int64{}
+	for k, v := range deadCounts {
+		counts[k] = v
+		total += v
+	}
+	return
+}
+
+// reinjectDeadLetters replay all stored dead letters, events failed again are stored as new dead letters
+func reinjectDeadLetters() (reinjected int, failed int, err error) {
+	reinjectMutex.Lock()
+	defer reinjectMutex.Unlock()
+
+	var files []string
+	if files, err = deadLetters.Detach(); err != nil {
+		return
+	}
+	for _, file := range files {
+		var f *os.File
+		if f, err = os.Open(file); err != nil {
+			return
+		}
+		// no line limit, an escaped raw event up to hard drop threshold can be several times larger
+		r := bufio.NewReader(f)
+		for {
+			var line []byte
+			if line, err = r.ReadBytes('\n'); err == io.EOF {
+				if err = nil; len(bytes.TrimSpace(line)) == 0 {
+					break
+				}
+			} else if err != nil {
+				break
+			}
+			var d DeadLetter
+			if err := json.Unmarshal(line, &d); err != nil {
+				log.Error().Err(err).Str("file", file).Msg("failed to decode dead letter")
+				continue
+			}
+			var ok bool
+			if d.Syslog {
+				ok, err = consumeSyslogMessage(d.Addr, d.Key, []byte(d.Raw))
+			} else {
+				ok, err = consumeRawEvent(d.Addr, d.Key, d.Tenant, []byte(d.Raw))
+			}
+			if err != nil {
+				// keep only this and following letters, already reinjected ones must not be replayed
+				if e := rewriteRemainder(file, line, r); e != nil {
+					log.Error().Err(e).Str("file", file).Msg("failed to rewrite remaining dead letters")
+				}
+				f.Close()
+				return
+			}
+			if ok {
+				reinjected++
+			} else {
+				failed++
+			}
+		}
+		f.Close()
+		if err != nil {
+			return
+		}
+		if err = os.Remove(file); err != nil {
+			return
+		}
+	}
+	log.Info().Int("reinjected", reinjected).Int("failed", failed).Msg("dead letters reinjected")
+	return
+}
+
+// rewriteRemainder replace file with the current line and the unread rest
+func rewriteRemainder(file string, line []byte, r io.Reader) (err error) {
+	var f *os.File
+	if f, err = os.Create(file + ".tmp"); err != nil {
+		return
+	}
+	if _, err = f.Write(line); err == nil {
+		_, err = io.Copy(f, r)
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(file + ".tmp")
+		return
+	}
+	return os.Rename(file+".tmp", file)
+}
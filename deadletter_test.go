@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReinjectDeadLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xlogd-deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if deadLetters, err = NewJournal(dir, "dead"); err != nil {
+		t.Fatal(err)
+	}
+	q := &memoryQueue{err: errors.New("disk full"), max: 2}
+	outputs = map[string]*Output{defaultOutput: {Name: defaultOutput, Queue: q}}
+	defer func() {
+		options = Options{}
+		outputs = map[string]*Output{}
+		deadLetters.Close()
+		deadLetters = nil
+	}()
+	for i := 0; i < 4; i++ {
+		raw := `{"message":"[2018/07/20 15:03:00.000] hello ` + strings.Repeat("!", i) + `","source":"/var/log/test/err/project.log"}`
+		if err = saveDeadLetter(DeadLetter{Addr: "127.0.0.1:1000", Key: "xlog", Reason: ReasonNoPipeline, Raw: raw}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// queue fails at third letter, only remaining letters are kept
+	reinjected, failed, err := reinjectDeadLetters()
+	if err == nil || reinjected != 2 || failed != 0 || q.Depth() != 2 {
+		t.Fatal("partial", reinjected, failed, err, q.Depth())
+	}
+	q.err = nil
+	reinjected, failed, err = reinjectDeadLetters()
+	if err != nil || reinjected != 2 || failed != 0 || q.Depth() != 4 {
+		t.Fatal("remainder", reinjected, failed, err, q.Depth())
+	}
+	// letters failed again are stored as new dead letters
+	if err = saveDeadLetter(DeadLetter{Addr: "127.0.0.1:1000", Key: "xlog", Reason: ReasonBadJSON, Raw: "{"}); err != nil {
+		t.Fatal(err)
+	}
+	if reinjected, failed, err = reinjectDeadLetters(); err != nil || reinjected != 0 || failed != 1 {
+		t.Fatal("failed again", reinjected, failed, err)
+	}
+	files, err := deadLetters.Detach()
+	if err != nil || len(files) != 1 {
+		t.Fatal("files", files, err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	geoCity *geoDatabase
+	geoASN  *geoDatabase
+)
+
+// geoDatabase a MaxMind DB file reloaded when modified
+type geoDatabase struct {
+	file string
+
+	mutex   sync.RWMutex
+	reader  *mmdbReader
+	modTime time.Time
+	size    int64
+}
+
+// reload open the file if modified since last load, the previous reader is kept on failure
+func (d *geoDatabase) reload() (changed bool, err error) {
+	var fi os.FileInfo
+	if fi, err = os.Stat(d.file); err != nil {
+		return
+	}
+	d.mutex.RLock()
+	changed = !fi.ModTime().Equal(d.modTime) || fi.Size() != d.size
+	d.mutex.RUnlock()
+	if !changed {
+		return
+	}
+	var r *mmdbReader
+	if r, err = openMMDB(d.file); err != nil {
+		return
+	}
+	d.mutex.Lock()
+	d.reader, d.modTime, d.size = r, fi.ModTime(), fi.Size()
+	d.mutex.Unlock()
+	return
+}
+
+// Lookup lookup ip in the current reader
+func (d *geoDatabase) Lookup(ip net.IP) (v interface{}, ok bool) {
+	d.mutex.RLock()
+	r := d.reader
+	d.mutex.RUnlock()
+	if r == nil {
+		return
+	}
+	var err error
+	if v, ok, err = r.Lookup(ip); err != nil {
+		log.Debug().Err(err).Str("file", d.file).Str("ip", ip.String()).Msg("failed to lookup geoip database")
+	}
+	return
+}
+
+// loadGeoIP load configured geoip databases
+func loadGeoIP() (err error) {
+	if len(options.Enrich.GeoIP.City) > 0 {
+		geoCity = &geoDatabase{file: options.Enrich.GeoIP.City}
+		if _, err = geoCity.reload(); err != nil {
+			return
+		}
+	}
+	if len(options.Enrich.GeoIP.ASN) > 0 {
+		geoASN = &geoDatabase{file: options.Enrich.GeoIP.ASN}
+		if _, err = geoASN.reload(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// geoIPRoutine reload modified geoip databases periodically
+func geoIPRoutine() {
+	if geoCity == nil && geoASN == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(options.Enrich.GeoIP.Reload) * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, d := range []*geoDatabase{geoCity, geoASN} {
+			if d == nil {
+				continue
+			}
+			if changed, err := d.reload(); err != nil {
+				log.Error().Err(err).Str("file", d.file).Msg("failed to reload geoip database")
+			} else if changed {
+				log.Info().Str("file", d.file).Msg("geoip database reloaded")
+			}
+		}
+	}
+}
+
+// enrichGeoIP add 'geo_country', 'geo_city', 'geo_location', 'asn' and 'as_org' from the ip field, existing ones are kept
+func enrichGeoIP(r *Record) {
+	v, ok := getField(*r, options.Enrich.GeoIP.Field)
+	if !ok {
+		return
+	}
+	ip := net.ParseIP(v)
+	if ip == nil {
+		return
+	}
+	values := map[string]interface{}{}
+	if geoCity != nil {
+		if city, ok := geoCity.Lookup(ip); ok {
+			values["geo_country"] = mmdbPath(city, "country", "iso_code")
+			values["geo_city"] = mmdbPath(city, "city", "names", "en")
+			lat, latOK := mmdbPath(city, "location", "latitude").(float64)
+			lon, lonOK := mmdbPath(city, "location", "longitude").(float64)
+			if latOK && lonOK {
+				values["geo_location"] = map[string]interface{}{"lat": lat, "lon": lon}
+			}
+		}
+	}
+	if geoASN != nil {
+		if asn, ok := geoASN.Lookup(ip); ok {
+			values["asn"] = mmdbPath(asn, "autonomous_system_number")
+			values["as_org"] = mmdbPath(asn, "autonomous_system_organization")
+		}
+	}
+	copyExtra(r)
+	for k, v := range values {
+		if v != nil {
+			assignExtraIfAbsent(r, k, v)
+		}
+	}
+}
+
+// enrichUserAgent add 'ua_browser', 'ua_browser_version', 'ua_os', 'ua_os_version' and 'ua_device' from the user agent field,
+// existing ones are kept
+func enrichUserAgent(r *Record) {
+	v, ok := getField(*r, options.Enrich.UserAgent.Field)
+	if !ok || len(v) == 0 {
+		return
+	}
+	u := parseUserAgent(v)
+	copyExtra(r)
+	for k, v := range map[string]string{
+		"ua_browser":         u.Browser,
+		"ua_browser_version": u.BrowserVersion,
+		"ua_os":              u.OS,
+		"ua_os_version":      u.OSVersion,
+		"ua_device":          u.Device,
+	} {
+		if len(v) > 0 {
+			assignExtraIfAbsent(r, k, v)
+		}
+	}
+}
+
+// enrichRecord enrich record with host inventory, geoip and user agent fields
+func enrichRecord(r *Record) {
+	if inventory != nil {
+		enrichInventory(r)
+	}
+	if geoCity != nil || geoASN != nil {
+		enrichGeoIP(r)
+	}
+	if len(options.Enrich.UserAgent.Field) > 0 {
+		enrichUserAgent(r)
+	}
+}
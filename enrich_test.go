@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnrichGeoIP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xlogd-geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "city.mmdb")
+	if err = ioutil.WriteFile(file, buildTestMMDB(6, 28, testGeoNetworks), 0644); err != nil {
+		t.Fatal(err)
+	}
+	options = Options{Enrich: EnrichOptions{
+		GeoIP:     GeoIPOptions{Field: "x_client_ip", City: file},
+		UserAgent: UserAgentOptions{Field: "x_user_agent"},
+	}}
+	defer func() {
+		options = Options{}
+		geoCity, geoASN = nil, nil
+	}()
+	if err = loadGeoIP(); err != nil {
+		t.Fatal(err)
+	}
+	r := Record{Extra: map[string]interface{}{"client_ip": "1.2.3.4", "user_agent": "curl/7.64.1"}}
+	enrichRecord(&r)
+	if r.Extra["geo_country"] != "CN" || r.Extra["geo_city"] != "Shanghai" || r.Extra["geo_location"] == nil {
+		t.Fatal("geoip", r.Extra)
+	}
+	if r.Extra["ua_browser"] != "curl" || r.Extra["ua_device"] != "bot" {
+		t.Fatal("user agent", r.Extra)
+	}
+	// existing extras are kept
+	r = Record{Extra: map[string]interface{}{"client_ip": "1.2.3.4", "user_agent": "curl/7.64.1", "geo_country": "US", "ua_browser": "custom"}}
+	if enrichRecord(&r); r.Extra["geo_country"] != "US" || r.Extra["geo_city"] != "Shanghai" || r.Extra["ua_browser"] != "custom" {
+		t.Fatal("existing", r.Extra)
+	}
+	// hot reload
+	networks := map[string]map[string]interface{}{"1.2.3.0/24": {"country": map[string]interface{}{"iso_code": "JP"}}}
+	if err = ioutil.WriteFile(file, buildTestMMDB(4, 24, networks), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(file, future, future)
+	if changed, err := geoCity.reload(); err != nil || !changed {
+		t.Fatal("reload", changed, err)
+	}
+	if changed, _ := geoCity.reload(); changed {
+		t.Fatal("not changed")
+	}
+	r = Record{Extra: map[string]interface{}{"client_ip": "1.2.3.4"}}
+	if enrichRecord(&r); r.Extra["geo_country"] != "JP" || r.Extra["geo_city"] != nil {
+		t.Fatal("reloaded", r.Extra)
+	}
+	// broken file keeps the previous database
+	ioutil.WriteFile(file, []byte("broken"), 0644)
+	if _, err = geoCity.reload(); err == nil {
+		t.Fatal("should fail")
+	}
+	r = Record{Extra: map[string]interface{}{"client_ip": "1.2.3.4"}}
+	if enrichRecord(&r); r.Extra["geo_country"] != "JP" {
+		t.Fatal("previous database", r.Extra)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// fingerprintMask a normalization rule, matches not valid are kept
+type fingerprintMask struct {
+	regexp      *regexp.Regexp
+	replacement string
+	validate    func(string) bool
+}
+
+// built-in normalization rules
+var fingerprintMasks = map[string]fingerprintMask{
+	"email":  {regexp: regexp.MustCompile(redactPresets["email"]), replacement: "<email>"},
+	"uuid":   {regexp: regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), replacement: "<uuid>"},
+	"quoted": {regexp: regexp.MustCompile(`"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`), replacement: "<str>"},
+	"hex":    {regexp: regexp.MustCompile(`\b0[xX][0-9a-fA-F]+\b|\b[0-9a-fA-F]{8,}\b`), replacement: "<hex>", validate: isHexToken},
+	"number": {regexp: regexp.MustCompile(`\d+(?:\.\d+)*`), replacement: "<num>"},
+}
+
+// built-in normalization rules applied by default, in order
+var defaultFingerprintMasks = []string{"email", "uuid", "quoted", "hex", "number"}
+
+var fingerprintSpaces = regexp.MustCompile(`\s+`)
+
+// isHexToken check 0x prefixed, or containing both digits and letters a-f, pure numbers and words are not hex
+func isHexToken(s string) bool {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return true
+	}
+	return strings.ContainsAny(s, "0123456789") && strings.ContainsAny(s, "abcdefABCDEF")
+}
+
+type fingerprintProcessor struct {
+	field  string
+	target string
+	masks  []fingerprintMask
+}
+
+// newFingerprintProcessor create a processor normalizing field with custom rules then built-in masks
+func newFingerprintProcessor(opts ProcessorOptions) (p fingerprintProcessor, err error) {
+	p.field, p.target = opts.Field, opts.Target
+	if len(p.field) == 0 {
+		p.field = "message"
+	}
+	if len(p.target) == 0 {
+		p.target = "x_fingerprint"
+	}
+	for _, rule := range opts.Rules {
+		var re *regexp.Regexp
+		if re, err = regexp.Compile(rule.Pattern); err != nil {
+			return
+		}
+		p.masks = append(p.masks, fingerprintMask{regexp: re, replacement: rule.Replacement})
+	}
+	names := opts.Masks
+	if names == nil {
+		names = defaultFingerprintMasks
+	}
+	for _, name := range names {
+		m, ok := fingerprintMasks[name]
+		if !ok {
+			err = errors.New("processor fingerprint: unknown mask '" + name + "'")
+			return
+		}
+		p.masks = append(p.masks, m)
+	}
+	return
+}
+
+// Normalize mask variable parts and collapse spaces
+func (p fingerprintProcessor) Normalize(s string) string {
+	for _, m := range p.masks {
+		if m.validate == nil {
+			s = m.regexp.ReplaceAllLiteralString(s, m.replacement)
+			continue
+		}
+		s = m.regexp.ReplaceAllStringFunc(s, func(v string) string {
+			if m.validate(v) {
+				return m.replacement
+			}
+			return v
+		})
+	}
+	return strings.TrimSpace(fingerprintSpaces.ReplaceAllLiteralString(s, " "))
+}
+
+// Process store the fingerprint to target and the normalized template to target with suffix '_template'
+func (p fingerprintProcessor) Process(r Record) []Record {
+	v, ok := getField(r, p.field)
+	if !ok {
+		return []Record{r}
+	}
+	t := p.Normalize(v)
+	sum := sha256.Sum256([]byte(t))
+	copyExtra(&r)
+	setField(&r, p.target, hex.EncodeToString(sum[:8]))
+	setField(&r, p.target+"_template", t)
+	return []Record{r}
+}
@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestFingerprintProcessor_Normalize(t *testing.T) {
+	p, err := newFingerprintProcessor(ProcessorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"user 12345 not found", "user <num> not found"},
+		{"timeout after 3.5s at 2018-07-20 15:03:00", "timeout after <num>s at <num>-<num>-<num> <num>:<num>:<num>"},
+		{"order 5f2b6c1e-9a4d-4c3b-8e7f-0a1b2c3d4e5f failed", "order <uuid> failed"},
+		{"bad pointer 0xdeadbeef in deadbeef", "bad pointer <hex> in deadbeef"},
+		{"trace a1b2c3d4e5f6 and 12345678", "trace <hex> and <num>"},
+		{`key "user:42" and 'x y' missing`, "key <str> and <str> missing"},
+		{"mail to bob@example.com  bounced\n", "mail to <email> bounced"},
+	}
+	for _, c := range cases {
+		if out := p.Normalize(c.in); out != c.out {
+			t.Errorf("%q\n got %q\nwant %q", c.in, out, c.out)
+		}
+	}
+}
+
+func TestFingerprintProcessor_Rules(t *testing.T) {
+	p, err := newFingerprintProcessor(ProcessorOptions{
+		Masks: []string{"number"},
+		Rules: []FingerprintRule{{Pattern: `/users/[^/\s]+`, Replacement: "/users/<id>"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := p.Normalize(`GET /users/bob42 "x" took 5ms`); out != `GET /users/<id> "x" took <num>ms` {
+		t.Fatal("rules", out)
+	}
+	if _, err = newFingerprintProcessor(ProcessorOptions{Masks: []string{"unknown"}}); err == nil {
+		t.Fatal("unknown mask")
+	}
+	if _, err = newFingerprintProcessor(ProcessorOptions{Rules: []FingerprintRule{{Pattern: "("}}}); err == nil {
+		t.Fatal("bad rule")
+	}
+}
+
+func TestFingerprintProcessor_Process(t *testing.T) {
+	p := newTestProcessor(t, ProcessorOptions{Type: "fingerprint", Topic: "err"})
+	r1 := p.Process(Record{Topic: "err", Message: "connection 10.0.0.1:3306 refused after 3 retries"})[0]
+	r2 := p.Process(Record{Topic: "err", Message: "connection 10.0.0.2:3307 refused after 5 retries"})[0]
+	r3 := p.Process(Record{Topic: "err", Message: "connection 10.0.0.1:3306 reset"})[0]
+	if r1.Extra["fingerprint_template"] != "connection <num>:<num> refused after <num> retries" {
+		t.Fatal("template", r1.Extra)
+	}
+	if fp, _ := r1.Extra["fingerprint"].(string); len(fp) != 16 || fp != r2.Extra["fingerprint"] || fp == r3.Extra["fingerprint"] {
+		t.Fatal("fingerprint", r1.Extra, r2.Extra, r3.Extra)
+	}
+	if r1.Message != "connection 10.0.0.1:3306 refused after 3 retries" {
+		t.Fatal("message should be kept")
+	}
+	if r := p.Process(Record{Topic: "access", Message: "GET / 200"})[0]; r.Extra != nil {
+		t.Fatal("should skip other topics")
+	}
+	p = newTestProcessor(t, ProcessorOptions{Type: "fingerprint", Target: "x_fp"})
+	if r := p.Process(Record{Message: "x 1"})[0]; r.Extra["fp"] == nil || r.Extra["fp_template"] != "x <num>" {
+		t.Fatal("target", r.Extra)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// HTTPResult response of HTTP ingestion endpoint
+type HTTPResult struct {
+	Accepted int    `json:"accepted"`
+	Rejected int    `json:"rejected"`
+	Error    string `json:"error,omitempty"`
+}
+
+func writeHTTPResult(rw http.ResponseWriter, status int, res HTTPResult) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(res)
+}
+
+// splitHTTPEvents split request body into raw events, body is either a single JSON event or newline-delimited events
+func splitHTTPEvents(body []byte) (raws [][]byte) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return
+	}
+	if json.Valid(body) {
+		raws = append(raws, body)
+		return
+	}
+	for _, line := range bytes.Split(body, []byte{'\n'}) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			raws = append(raws, line)
+		}
+	}
+	return
+}
+
+func httpHandlerFunc(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeHTTPResult(rw, http.StatusMethodNotAllowed, HTTPResult{Error: "method not allowed"})
+		return
+	}
+	// authenticate with basic authentication, empty username means the legacy single-password form
+	var tenant string
+	if authRequired() {
+		user, pass, _ := req.BasicAuth()
+		var ok bool
+		if tenant, ok = authenticate(user, pass); !ok {
+			rw.Header().Set("WWW-Authenticate", `Basic realm="xlogd"`)
+			writeHTTPResult(rw, http.StatusUnauthorized, HTTPResult{Error: "authentication required"})
+			return
+		}
+	}
+	// key must be mapped to a pipeline
+	key := strings.Trim(req.URL.Path, "/")
+	if len(key) == 0 {
+		key = "xlog"
+	}
+	if _, ok := resolvePipeline(key); !ok {
+		writeHTTPResult(rw, http.StatusNotFound, HTTPResult{Error: "no pipeline for key '" + key + "'"})
+		return
+	}
+	// refuse if queues are above high-water mark
+	if isThrottled() {
+		rw.Header().Set("Retry-After", "10")
+		writeHTTPResult(rw, http.StatusServiceUnavailable, HTTPResult{Error: "queue is above high-water mark"})
+		return
+	}
+	// read the body
+	body, err := ioutil.ReadAll(http.MaxBytesReader(rw, req.Body, int64(options.HTTP.MaxBody)))
+	if err != nil {
+		writeHTTPResult(rw, http.StatusRequestEntityTooLarge, HTTPResult{Error: err.Error()})
+		return
+	}
+	log.Debug().Str("addr", req.RemoteAddr).Int("body-length", len(body)).Msg("new http request")
+	// consume all events
+	var res HTTPResult
+	for _, raw := range splitHTTPEvents(body) {
+		ok, err := consumeRawEvent(req.RemoteAddr, key, tenant, raw)
+		if err != nil {
+			res.Error = "failed to persist event: " + err.Error()
+			writeHTTPResult(rw, http.StatusInternalServerError, res)
+			return
+		}
+		if ok {
+			res.Accepted++
+		} else {
+			res.Rejected++
+		}
+	}
+	writeHTTPResult(rw, http.StatusOK, res)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSplitHTTPEvents(t *testing.T) {
+	if len(splitHTTPEvents([]byte(" \n "))) != 0 {
+		t.Fatal("empty")
+	}
+	raws := splitHTTPEvents([]byte("{\n  \"message\": \"hello\"\n}\n"))
+	if len(raws) != 1 {
+		t.Fatal("single", len(raws))
+	}
+	raws = splitHTTPEvents([]byte("{\"message\": \"hello\"}\n\n{\"message\": \"world\"}\nbad\n"))
+	if len(raws) != 3 {
+		t.Fatal("ndjson", len(raws))
+	}
+	if string(raws[2]) != "bad" {
+		t.Fatal("ndjson", string(raws[2]))
+	}
+}
+
+func doHTTPRequest(method, target, body string, auth ...string) (*httptest.ResponseRecorder, HTTPResult) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if len(auth) == 2 {
+		req.SetBasicAuth(auth[0], auth[1])
+	}
+	rw := httptest.NewRecorder()
+	httpHandlerFunc(rw, req)
+	var res HTTPResult
+	json.Unmarshal(rw.Body.Bytes(), &res)
+	return rw, res
+}
+
+func TestHTTPHandlerFunc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xlogd-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if deadLetters, err = NewJournal(dir, "dead"); err != nil {
+		t.Fatal(err)
+	}
+	q := &memoryQueue{}
+	outputs = map[string]*Output{defaultOutput: {Name: defaultOutput, Queue: q}}
+	offset := 0
+	options = Options{
+		HTTP:      HTTPOptions{MaxBody: 1024 * 1024},
+		Auth:      AuthOptions{Users: map[string]string{"team-a": "pass-a"}},
+		Pipelines: []PipelineOptions{{Key: "xlog", Output: defaultOutput, TimeOffset: &offset}},
+	}
+	defer func() {
+		options = Options{}
+		outputs = map[string]*Output{}
+		atomic.StoreInt32(&throttled, 0)
+		deadLetters.Close()
+		deadLetters = nil
+	}()
+	event := `{"message":"[2018/07/20 15:03:00.000] hello","source":"/var/log/test/err/project.log"}`
+
+	if rw, _ := doHTTPRequest("GET", "/xlog", ""); rw.Code != http.StatusMethodNotAllowed {
+		t.Fatal("method", rw.Code)
+	}
+	if rw, _ := doHTTPRequest("POST", "/xlog", event); rw.Code != http.StatusUnauthorized || rw.Header().Get("WWW-Authenticate") == "" {
+		t.Fatal("no credentials", rw.Code)
+	}
+	if rw, _ := doHTTPRequest("POST", "/xlog", event, "team-a", "bad"); rw.Code != http.StatusUnauthorized {
+		t.Fatal("bad credentials", rw.Code)
+	}
+	if rw, _ := doHTTPRequest("POST", "/audit", event, "team-a", "pass-a"); rw.Code != http.StatusNotFound {
+		t.Fatal("unmapped key", rw.Code)
+	}
+	// accepted and rejected counts
+	rw, res := doHTTPRequest("POST", "/xlog", event+"\n"+event+"\nbad\n", "team-a", "pass-a")
+	if rw.Code != http.StatusOK || res.Accepted != 2 || res.Rejected != 1 || q.Depth() != 2 {
+		t.Fatal("counts", rw.Code, res, q.Depth())
+	}
+	if o, err := DecodeOperation(q.items[0]); err != nil || !strings.Contains(string(o.Body), `"tenant":"team-a"`) {
+		t.Fatal("tenant", string(o.Body), err)
+	}
+	// refused above high-water mark
+	atomic.StoreInt32(&throttled, 1)
+	if rw, _ = doHTTPRequest("POST", "/xlog", event, "team-a", "pass-a"); rw.Code != http.StatusServiceUnavailable || rw.Header().Get("Retry-After") == "" {
+		t.Fatal("throttled", rw.Code)
+	}
+	atomic.StoreInt32(&throttled, 0)
+	// queue failure
+	q.err = errors.New("disk full")
+	rw, res = doHTTPRequest("POST", "/xlog", event, "team-a", "pass-a")
+	if rw.Code != http.StatusInternalServerError || res.Accepted != 0 || len(res.Error) == 0 {
+		t.Fatal("queue failure", rw.Code, res)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-yaml/yaml"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	inventory *hostInventory
+
+	unknownHosts      = map[string]struct{}{}
+	unknownHostsMutex = &sync.Mutex{}
+)
+
+// takeUnknownHosts number of distinct unknown hostnames since last call
+func takeUnknownHosts() (n int64) {
+	unknownHostsMutex.Lock()
+	defer unknownHostsMutex.Unlock()
+	n = int64(len(unknownHosts))
+	unknownHosts = map[string]struct{}{}
+	return
+}
+
+// hostInventory host attributes by hostname or hostname glob, loaded from a YAML or CSV file
+type hostInventory struct {
+	file string
+
+	mutex   sync.RWMutex
+	exact   map[string]map[string]string
+	globs   []string
+	attrs   map[string]map[string]string
+	modTime time.Time
+	size    int64
+}
+
+// parseInventory parse inventory file content, YAML is a map of hostname to attributes,
+// CSV has a header row with 'hostname' as the first column
+func parseInventory(file string, buf []byte) (hosts map[string]map[string]string, err error) {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(buf, &hosts)
+		return
+	case ".csv":
+		var rows [][]string
+		if rows, err = csv.NewReader(bytes.NewReader(buf)).ReadAll(); err != nil {
+			return
+		}
+		if len(rows) == 0 || len(rows[0]) == 0 || strings.TrimSpace(rows[0][0]) != "hostname" {
+			err = errors.New("inventory: first column of csv header should be 'hostname'")
+			return
+		}
+		hosts = map[string]map[string]string{}
+		for _, row := range rows[1:] {
+			attrs := map[string]string{}
+			for i, v := range row[1:] {
+				if v = strings.TrimSpace(v); len(v) > 0 {
+					attrs[strings.TrimSpace(rows[0][i+1])] = v
+				}
+			}
+			hosts[strings.TrimSpace(row[0])] = attrs
+		}
+		return
+	}
+	err = errors.New("inventory: unsupported file type '" + filepath.Ext(file) + "'")
+	return
+}
+
+// reload load the file if modified since last load, the previous inventory is kept on failure
+func (v *hostInventory) reload() (changed bool, err error) {
+	var fi os.FileInfo
+	if fi, err = os.Stat(v.file); err != nil {
+		return
+	}
+	v.mutex.RLock()
+	changed = !fi.ModTime().Equal(v.modTime) || fi.Size() != v.size
+	v.mutex.RUnlock()
+	if !changed {
+		return
+	}
+	var buf []byte
+	if buf, err = ioutil.ReadFile(v.file); err != nil {
+		return
+	}
+	var hosts map[string]map[string]string
+	if hosts, err = parseInventory(v.file, buf); err != nil {
+		return
+	}
+	// exact hostnames first, then globs from the longest
+	exact := map[string]map[string]string{}
+	var globs []string
+	for h, attrs := range hosts {
+		if _, err = path.Match(h, ""); err != nil {
+			return
+		}
+		if strings.ContainsAny(h, "*?[") {
+			globs = append(globs, h)
+		} else {
+			exact[h] = attrs
+		}
+	}
+	sort.Slice(globs, func(i, j int) bool {
+		if len(globs[i]) != len(globs[j]) {
+			return len(globs[i]) > len(globs[j])
+		}
+		return globs[i] < globs[j]
+	})
+	v.mutex.Lock()
+	v.exact, v.globs, v.attrs, v.modTime, v.size = exact, globs, hosts, fi.ModTime(), fi.Size()
+	v.mutex.Unlock()
+	return
+}
+
+// Lookup find attributes of hostname
+func (v *hostInventory) Lookup(hostname string) (attrs map[string]string, ok bool) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	if attrs, ok = v.exact[hostname]; ok {
+		return
+	}
+	for _, g := range v.globs {
+		if ok, _ = path.Match(g, hostname); ok {
+			attrs = v.attrs[g]
+			return
+		}
+	}
+	return
+}
+
+// loadInventory load the configured inventory file
+func loadInventory() (err error) {
+	if len(options.Enrich.Inventory.File) == 0 {
+		return
+	}
+	inventory = &hostInventory{file: options.Enrich.Inventory.File}
+	_, err = inventory.reload()
+	return
+}
+
+// inventoryRoutine reload modified inventory file periodically
+func inventoryRoutine() {
+	if inventory == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(options.Enrich.Inventory.Reload) * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if changed, err := inventory.reload(); err != nil {
+			log.Error().Err(err).Str("file", inventory.file).Msg("failed to reload inventory")
+		} else if changed {
+			log.Info().Str("file", inventory.file).Msg("inventory reloaded")
+		}
+	}
+}
+
+// enrichInventory merge host attributes into extra without overwriting, distinct unknown hosts are counted and optionally tagged
+func enrichInventory(r *Record) {
+	attrs, ok := inventory.Lookup(r.Hostname)
+	if !ok {
+		unknownHostsMutex.Lock()
+		unknownHosts[r.Hostname] = struct{}{}
+		unknownHostsMutex.Unlock()
+		if options.Enrich.Inventory.TagUnknown {
+			copyExtra(r)
+			r.Extra["unknown_host"] = true
+		}
+		return
+	}
+	copyExtra(r)
+	for k, v := range attrs {
+		assignExtraIfAbsent(r, k, v)
+	}
+}
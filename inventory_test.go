@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseInventory(t *testing.T) {
+	hosts, err := parseInventory("hosts.yml", []byte("web-01:\n  datacenter: sh1\n  rack: 12\n\"web-*\":\n  team: web\n"))
+	if err != nil || hosts["web-01"]["rack"] != "12" || hosts["web-*"]["team"] != "web" {
+		t.Fatal("yaml", hosts, err)
+	}
+	hosts, err = parseInventory("hosts.CSV", []byte("hostname,datacenter,team\nweb-01,sh1,web\ndb-*,,dba\n"))
+	if err != nil || hosts["web-01"]["datacenter"] != "sh1" || hosts["db-*"]["team"] != "dba" || len(hosts["db-*"]) != 1 {
+		t.Fatal("csv", hosts, err)
+	}
+	if _, err = parseInventory("hosts.csv", []byte("host,team\n")); err == nil {
+		t.Fatal("bad header")
+	}
+	if _, err = parseInventory("hosts.txt", nil); err == nil {
+		t.Fatal("bad type")
+	}
+}
+
+func TestEnrichInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xlogd-inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "hosts.yml")
+	content := `
+web-01:
+  datacenter: sh1
+  tier: gold
+"web-*":
+  datacenter: sh2
+  team: web
+"*":
+  team: ops
+`
+	if err = ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	options = Options{Enrich: EnrichOptions{Inventory: InventoryOptions{File: file, TagUnknown: true}}}
+	defer func() {
+		options = Options{}
+		inventory = nil
+	}()
+	if err = loadInventory(); err != nil {
+		t.Fatal(err)
+	}
+	r := Record{Hostname: "web-01", Extra: map[string]interface{}{"tier": "silver"}}
+	enrichRecord(&r)
+	if r.Extra["datacenter"] != "sh1" || r.Extra["tier"] != "silver" || r.Extra["team"] != nil {
+		t.Fatal("exact", r.Extra)
+	}
+	r = Record{Hostname: "web-02"}
+	if enrichRecord(&r); r.Extra["datacenter"] != "sh2" || r.Extra["team"] != "web" {
+		t.Fatal("glob", r.Extra)
+	}
+	r = Record{Hostname: "db-01"}
+	if enrichRecord(&r); r.Extra["team"] != "ops" {
+		t.Fatal("wildcard", r.Extra)
+	}
+	// reload without wildcard
+	if err = ioutil.WriteFile(file, []byte("web-01:\n  datacenter: sh3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(file, future, future)
+	if changed, err := inventory.reload(); err != nil || !changed {
+		t.Fatal("reload", changed, err)
+	}
+	takeUnknownHosts()
+	r = Record{Hostname: "db-01"}
+	if enrichRecord(&r); r.Extra["unknown_host"] != true {
+		t.Fatal("unknown", r.Extra)
+	}
+	r = Record{Hostname: "db-01"}
+	enrichRecord(&r)
+	if n := takeUnknownHosts(); n != 1 {
+		t.Fatal("distinct unknown hosts", n)
+	}
+	r = Record{Hostname: "web-01"}
+	if enrichRecord(&r); r.Extra["datacenter"] != "sh3" {
+		t.Fatal("reloaded", r.Extra)
+	}
+}
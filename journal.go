@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Journal append-only JSON lines file, rotated daily
+type Journal struct {
+	dir    string
+	prefix string
+	mutex  sync.Mutex
+	day    string
+	file   *os.File
+}
+
+// NewJournal create a journal writing files like '<dir>/<prefix>-2018-04-11.jsonl'
+func NewJournal(dir, prefix string) (j *Journal, err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	j = &Journal{dir: dir, prefix: prefix}
+	return
+}
+
+// Append marshal and append a value as a single line
+func (j *Journal) Append(v interface{}) (err error) {
+	var buf []byte
+	if buf, err = json.Marshal(v); err != nil {
+		return
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	// rotate file if day changed
+	day := time.Now().Format("2006-01-02")
+	if j.file == nil || j.day != day {
+		if j.file != nil {
+			j.file.Close()
+			j.file = nil
+		}
+		if j.file, err = os.OpenFile(filepath.Join(j.dir, j.prefix+"-"+day+".jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+			return
+		}
+		j.day = day
+	}
+	_, err = j.file.Write(append(buf, '\n'))
+	return
+}
+
+// Close close the current file, next Append will reopen it
+func (j *Journal) Close() (err error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.file != nil {
+		err = j.file.Close()
+		j.file = nil
+	}
+	return
+}
+
+// Detach close the current file and rename all journal files aside with suffix '.detached', returns the renamed files,
+// files detached previously but not removed are also returned and appended if detached again, subsequent appends will
+// start new files
+func (j *Journal) Detach() (files []string, err error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+	var names []string
+	if names, err = filepath.Glob(filepath.Join(j.dir, j.prefix+"-*.jsonl")); err != nil {
+		return
+	}
+	for _, name := range names {
+		if _, err = os.Stat(name + ".detached"); os.IsNotExist(err) {
+			if err = os.Rename(name, name+".detached"); err != nil {
+				return
+			}
+			continue
+		} else if err != nil {
+			return
+		}
+		if err = appendFile(name+".detached", name); err != nil {
+			return
+		}
+		if err = os.Remove(name); err != nil {
+			return
+		}
+	}
+	files, err = filepath.Glob(filepath.Join(j.dir, j.prefix+"-*.jsonl.detached"))
+	return
+}
+
+// appendFile append content of file src to file dst
+func appendFile(dst, src string) (err error) {
+	var s, d *os.File
+	if s, err = os.Open(src); err != nil {
+		return
+	}
+	defer s.Close()
+	if d, err = os.OpenFile(dst, os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+		return
+	}
+	if _, err = io.Copy(d, s); err != nil {
+		d.Close()
+		return
+	}
+	return d.Close()
+}
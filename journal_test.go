@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xlogd-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	j, err := NewJournal(dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if err = j.Append(map[string]int{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+	files, err := j.Detach()
+	if err != nil || len(files) != 1 {
+		t.Fatal("detach", files, err)
+	}
+	// detached again on the same day, earlier detached file is appended instead of overwritten
+	if err = j.Append(map[string]int{"b": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if files, err = j.Detach(); err != nil || len(files) != 1 {
+		t.Fatal("detach again", files, err)
+	}
+	buf, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "{\"a\":1}\n{\"b\":2}\n" {
+		t.Fatal("content", string(buf))
+	}
+	if !strings.HasSuffix(files[0], ".jsonl.detached") {
+		t.Fatal("name", files[0])
+	}
+}
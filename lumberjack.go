@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	lumberjackMaxPayloadSize = 64 * 1024 * 1024
+)
+
+var (
+	lumberjackListener net.Listener
+
+	errLumberjackPayloadTooLarge = errors.New("lumberjack: payload too large")
+)
+
+// lumberjackLimitReader fails instead of returning io.EOF once more than n bytes are read
+type lumberjackLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *lumberjackLimitReader) Read(p []byte) (n int, err error) {
+	n, err = l.r.Read(p)
+	if l.n -= int64(n); l.n < 0 {
+		err = errLumberjackPayloadTooLarge
+	}
+	return
+}
+
+// readLumberjackFrame read a JSON frame or a compressed frame of lumberjack v2 protocol, io.EOF is returned if no more frame,
+// no more than window events are accepted
+func readLumberjackFrame(r io.Reader, events [][]byte, seq *uint32, window uint32) ([][]byte, error) {
+	var h [2]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return events, err
+	}
+	if h[0] != '2' {
+		return events, errors.New("lumberjack: unsupported protocol version")
+	}
+	switch h[1] {
+	case 'J':
+		if uint32(len(events)) >= window {
+			return events, errors.New("lumberjack: more events than window size")
+		}
+		var l uint32
+		if err := binary.Read(r, binary.BigEndian, seq); err != nil {
+			return events, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &l); err != nil {
+			return events, err
+		}
+		if l > lumberjackMaxPayloadSize {
+			return events, errLumberjackPayloadTooLarge
+		}
+		buf := make([]byte, l)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return events, err
+		}
+		return append(events, buf), nil
+	case 'C':
+		var l uint32
+		if err := binary.Read(r, binary.BigEndian, &l); err != nil {
+			return events, err
+		}
+		if l > lumberjackMaxPayloadSize {
+			return events, errLumberjackPayloadTooLarge
+		}
+		zr, err := zlib.NewReader(io.LimitReader(r, int64(l)))
+		if err != nil {
+			return events, err
+		}
+		defer zr.Close()
+		// limit decompressed bytes as well, a small compressed frame can inflate enormously
+		lr := &lumberjackLimitReader{r: zr, n: lumberjackMaxPayloadSize}
+		for {
+			if events, err = readLumberjackFrame(lr, events, seq, window); err == io.EOF {
+				return events, nil
+			} else if err != nil {
+				return events, err
+			}
+		}
+	}
+	return events, errors.New("lumberjack: unexpected frame type '" + string(h[1:]) + "'")
+}
+
+// readLumberjackBatch read a window of events of lumberjack v2 protocol, returns events and sequence of last event,
+// windows larger than maxWindow events or lumberjackMaxPayloadSize bytes in total are rejected
+func readLumberjackBatch(r io.Reader, maxWindow uint32) (events [][]byte, seq uint32, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(r, h[:]); err != nil {
+		return
+	}
+	if h[0] != '2' || h[1] != 'W' {
+		err = errors.New("lumberjack: window frame expected")
+		return
+	}
+	var window uint32
+	if err = binary.Read(r, binary.BigEndian, &window); err != nil {
+		return
+	}
+	if window > maxWindow {
+		err = errors.New("lumberjack: window size too large")
+		return
+	}
+	var size int
+	for uint32(len(events)) < window {
+		n := len(events)
+		if events, err = readLumberjackFrame(r, events, &seq, window); err != nil {
+			return
+		}
+		for _, e := range events[n:] {
+			size += len(e)
+		}
+		if size > lumberjackMaxPayloadSize {
+			err = errLumberjackPayloadTooLarge
+			return
+		}
+	}
+	return
+}
+
+// writeLumberjackACK acknowledge events up to the sequence
+func writeLumberjackACK(w io.Writer, seq uint32) error {
+	buf := []byte{'2', 'A', 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(buf[2:], seq)
+	_, err := w.Write(buf)
+	return err
+}
+
+func lumberjackRoutine() {
+	for {
+		conn, err := lumberjackListener.Accept()
+		if err != nil {
+			log.Info().Err(err).Msg("lumberjack server closed")
+			return
+		}
+		go lumberjackConnRoutine(conn)
+	}
+}
+
+func lumberjackConnRoutine(conn net.Conn) {
+	defer conn.Close()
+	addr := conn.RemoteAddr().String()
+	log.Info().Str("addr", addr).Msg("lumberjack connection established")
+	r := bufio.NewReader(conn)
+	for {
+		// stop reading if queues are above high-water mark
+		waitUnthrottled(nil)
+		events, seq, err := readLumberjackBatch(r, uint32(options.Lumberjack.MaxWindow))
+		if err != nil {
+			log.Info().Err(err).Str("addr", addr).Msg("lumberjack connection closed")
+			return
+		}
+		log.Debug().Str("addr", addr).Int("events", len(events)).Msg("lumberjack window received")
+		// events are persisted in disk queue or dead letter store before ACK, the window is resent if not ACKed
+		for _, raw := range events {
+			if _, err = consumeRawEvent(addr, options.Lumberjack.Key, "", raw); err != nil {
+				log.Error().Err(err).Str("addr", addr).Msg("failed to persist lumberjack window, closing without ACK")
+				return
+			}
+		}
+		if err = writeLumberjackACK(conn, seq); err != nil {
+			log.Info().Err(err).Str("addr", addr).Msg("lumberjack connection closed")
+			return
+		}
+	}
+}
+
+func startLumberjack() (err error) {
+	if len(options.Lumberjack.Bind) == 0 {
+		return
+	}
+	if lumberjackListener, err = net.Listen("tcp", options.Lumberjack.Bind); err != nil {
+		return
+	}
+	go lumberjackRoutine()
+	log.Info().Str("bind", options.Lumberjack.Bind).Msg("lumberjack server started")
+	return
+}
+
+func stopLumberjack() {
+	if lumberjackListener != nil {
+		lumberjackListener.Close()
+	}
+}
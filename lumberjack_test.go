@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func appendLumberjackJSON(buf []byte, seq uint32, payload string) []byte {
+	buf = append(buf, '2', 'J', 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf[len(buf)-8:], seq)
+	binary.BigEndian.PutUint32(buf[len(buf)-4:], uint32(len(payload)))
+	return append(buf, payload...)
+}
+
+func TestReadLumberjackBatch(t *testing.T) {
+	// window of 3, 1 plain event and 2 compressed events
+	buf := []byte{'2', 'W', 0, 0, 0, 3}
+	buf = appendLumberjackJSON(buf, 1, `{"message":"1"}`)
+	var zbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	zw.Write(appendLumberjackJSON(appendLumberjackJSON(nil, 2, `{"message":"2"}`), 3, `{"message":"3"}`))
+	zw.Close()
+	buf = append(buf, '2', 'C', 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf[len(buf)-4:], uint32(zbuf.Len()))
+	buf = append(buf, zbuf.Bytes()...)
+
+	r := bytes.NewReader(buf)
+	events, seq, err := readLumberjackBatch(r, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || seq != 3 {
+		t.Fatal("events", len(events), seq)
+	}
+	if string(events[2]) != `{"message":"3"}` {
+		t.Fatal("payload", string(events[2]))
+	}
+	if _, _, err = readLumberjackBatch(r, 3); err == nil {
+		t.Fatal("eof")
+	}
+
+	var ack bytes.Buffer
+	writeLumberjackACK(&ack, seq)
+	if !bytes.Equal(ack.Bytes(), []byte{'2', 'A', 0, 0, 0, 3}) {
+		t.Fatal("ack", ack.Bytes())
+	}
+}
+
+func appendLumberjackCompressed(buf []byte, frames []byte) []byte {
+	var zbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	zw.Write(frames)
+	zw.Close()
+	buf = append(buf, '2', 'C', 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf[len(buf)-4:], uint32(zbuf.Len()))
+	return append(buf, zbuf.Bytes()...)
+}
+
+func TestReadLumberjackBatch_Limits(t *testing.T) {
+	// window larger than max window
+	if _, _, err := readLumberjackBatch(bytes.NewReader([]byte{'2', 'W', 0xff, 0xff, 0xff, 0xff}), 10); err == nil {
+		t.Fatal("should reject large window")
+	}
+	// more events than announced window
+	buf := []byte{'2', 'W', 0, 0, 0, 1}
+	buf = appendLumberjackCompressed(buf, appendLumberjackJSON(appendLumberjackJSON(nil, 1, `{"message":"1"}`), 2, `{"message":"2"}`))
+	if _, _, err := readLumberjackBatch(bytes.NewReader(buf), 10); err == nil {
+		t.Fatal("should reject events beyond window")
+	}
+	// total bytes of window beyond max payload size
+	half := string(make([]byte, lumberjackMaxPayloadSize/2+1))
+	buf = appendLumberjackJSON(appendLumberjackJSON([]byte{'2', 'W', 0, 0, 0, 2}, 1, half), 2, half)
+	if _, _, err := readLumberjackBatch(bytes.NewReader(buf), 10); err != errLumberjackPayloadTooLarge {
+		t.Fatal("should reject large window payload", err)
+	}
+	// compressed frame inflating beyond max payload size
+	frame := appendLumberjackJSON(nil, 1, string(make([]byte, lumberjackMaxPayloadSize)))
+	buf = appendLumberjackCompressed([]byte{'2', 'W', 0, 0, 0, 1}, frame)
+	if _, _, err := readLumberjackBatch(bytes.NewReader(buf), 10); err != errLumberjackPayloadTooLarge {
+		t.Fatal("should reject inflated payload", err)
+	}
+}
+
+func TestLumberjackConnRoutine_NoACKOnFailure(t *testing.T) {
+	q := &memoryQueue{err: errors.New("disk full")}
+	outputs = map[string]*Output{defaultOutput: {Name: defaultOutput, Queue: q}}
+	options = Options{Lumberjack: LumberjackOptions{Key: "xlog", MaxWindow: 10}}
+	defer func() {
+		options = Options{}
+		outputs = map[string]*Output{}
+	}()
+	client, server := net.Pipe()
+	defer client.Close()
+	go lumberjackConnRoutine(server)
+	buf := appendLumberjackJSON([]byte{'2', 'W', 0, 0, 0, 1}, 1, `{"message":"[2018/07/20 15:03:00.000] hello","source":"/var/log/test/err/project.log"}`)
+	if _, err := client.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	var ack [6]byte
+	if _, err := io.ReadFull(client, ack[:]); err != io.EOF {
+		t.Fatal("should close without ACK", ack, err)
+	}
+}
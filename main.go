@@ -1,25 +1,24 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
+	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
-	"runtime"
+	"path"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/juju/ratelimit"
-	"github.com/olivere/elastic"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/yankeguo/diskqueue"
 	"github.com/yankeguo/redcon"
 )
 
@@ -28,16 +27,19 @@ var (
 	options     Options
 	dev         bool
 
-	server *redcon.Server
-	client *elastic.Client
+	server     redisServer
+	httpServer *http.Server
 
-	queue diskqueue.DiskQueue
+	outputs = map[string]*Output{}
+
+	failures *Journal
 
-	limiter       *ratelimit.Bucket
 	totalConns    int64
 	connsSum      = map[string]int{}
 	connsSumMutex = &sync.Mutex{}
 	totalCount    int64
+	totalFailed   int64
+	totalDup      int64
 
 	shutdown      bool
 	shutdownGroup = &sync.WaitGroup{}
@@ -67,53 +69,124 @@ func decreaseConnsSum(addr string) int {
 }
 
 func acceptHandlerFunc(conn redcon.Conn) bool {
+	conn.SetContext(&Session{})
 	log.Info().Int64("conns", atomic.AddInt64(&totalConns, 1)).Int("conns-dup", increaseConnsSum(conn.RemoteAddr())).Str("addr", conn.RemoteAddr()).Msg("connection established")
 	return true
 }
 
-func checkRecordTopic(r Record) bool {
-	if stringSliceContainsIgnoreCase(options.EnforceKeyword, r.Topic) && len(r.Keyword) == 0 {
+// resolvePipeline find the first pipeline matching the key, all keys are accepted if no pipeline configured
+func resolvePipeline(key string) (p PipelineOptions, ok bool) {
+	if len(options.Pipelines) == 0 {
+		p = PipelineOptions{
+			Key:        key,
+			TimeOffset: &options.TimeOffset,
+			Output:     defaultOutput,
+		}
+		ok = true
+		return
+	}
+	for _, p = range options.Pipelines {
+		if ok, _ = path.Match(p.Key, key); ok {
+			return
+		}
+	}
+	return
+}
+
+func checkRecordTopic(p PipelineOptions, r Record) bool {
+	if (stringSliceContainsIgnoreCase(options.EnforceKeyword, r.Topic) || stringSliceContainsIgnoreCase(p.EnforceKeyword, r.Topic)) && len(r.Keyword) == 0 {
 		return false
 	}
-	if stringSliceContainsIgnoreCase(options.Ignore, r.Topic) {
+	if stringSliceContainsIgnoreCase(options.Ignore, r.Topic) || stringSliceContainsIgnoreCase(p.Ignore, r.Topic) {
 		return false
 	}
 	return true
 }
 
-func consumeRawEvent(raw []byte) {
-	// ignore event > 1mb
-	if len(raw) > 1000000 {
+// consumeRawEvent parse and queue a raw event pushed to key by tenant, returns false if event is saved as a dead letter,
+// err is set if event is neither queued nor saved
+func consumeRawEvent(addr string, key string, tenant string, raw []byte) (ok bool, err error) {
+	// dead letter event > hard drop threshold
+	if options.Limits.HardDrop > 0 && len(raw) > options.Limits.HardDrop {
+		err = saveDeadLetter(DeadLetter{Addr: addr, Key: key, Tenant: tenant, Reason: ReasonOversize, Raw: string(raw)})
 		return
 	}
-	// warn event > 500k
-	if len(raw) > 500000 {
-		log.Warn().Int("raw-length", len(raw)).Msg("raw message larger than 500k")
-	}
 	log.Debug().Int("raw-length", len(raw)).Msg("raw message")
+	// resolve pipeline
+	p, found := resolvePipeline(key)
+	if !found {
+		err = saveDeadLetter(DeadLetter{Addr: addr, Key: key, Tenant: tenant, Reason: ReasonNoPipeline, Raw: string(raw)})
+		return
+	}
 	// unmarshal event
 	var event Event
-	if err := json.Unmarshal(raw, &event); err != nil {
-		log.Debug().Err(err).Str("event", string(raw)).Msg("failed to unmarshal event")
+	if e := json.Unmarshal(raw, &event); e != nil {
+		log.Debug().Err(e).Str("event", string(raw)).Msg("failed to unmarshal event")
+		err = saveDeadLetter(DeadLetter{Addr: addr, Key: key, Tenant: tenant, Reason: ReasonBadJSON, Raw: string(raw)})
 		return
 	}
 	// convert to record
-	if record, ok := event.ToRecord(options.TimeOffset); ok {
-		// check should keyword be enforced
-		if checkRecordTopic(record) {
-			// convert to operation
-			o := record.ToOperation()
-			// encode operation
-			var buf bytes.Buffer
-			if err := gob.NewEncoder(&buf).Encode(o); err != nil {
-				log.Error().Err(err).Msg("failed to encode operation")
-				return
+	record, reason, converted := event.ToRecord(*p.TimeOffset)
+	if !converted {
+		log.Debug().Str("event", string(raw)).Str("reason", reason).Msg("failed to convert record")
+		err = saveDeadLetter(DeadLetter{Addr: addr, Key: key, Tenant: tenant, Reason: reason, Raw: string(raw)})
+		return
+	}
+	record.Tenant = tenant
+	// truncate event > limits
+	if truncateRecord(&record, len(raw)); record.Truncated {
+		log.Warn().Int("raw-length", len(raw)).Int("message-length", record.OriginalLength).Str("addr", addr).Msg("oversized event truncated")
+	}
+	if err = enqueueRecord(p, record); err != nil {
+		return
+	}
+	ok = true
+	return
+}
+
+// enqueueRecord run processors, convert records to operations and put into queue of pipeline output
+func enqueueRecord(p PipelineOptions, record Record) (err error) {
+	for _, record := range runProcessors(options.processors, record) {
+		output := p.Output
+		// the first matched rule decides, check ignore and enforce keyword unless rule is 'keep'
+		rule, ok := matchRule(options.Rules, record)
+		if ok && rule.Action == RuleDrop {
+			continue
+		}
+		if !(ok && rule.Action == RuleKeep) && !checkRecordTopic(p, record) {
+			continue
+		}
+		// sample
+		if !sampleRecord(&record, time.Now()) {
+			continue
+		}
+		// enrich with host inventory, geoip and user agent, only records to be queued
+		enrichRecord(&record)
+		// redact secrets and PII
+		redactRecord(&record)
+		// convert to operation
+		o := record.ToOperation()
+		if len(options.DocumentIDFields) > 0 || record.Offset != nil {
+			o.ID = record.DocumentID(options.DocumentIDFields)
+		}
+		if ok && rule.Action == RuleRoute {
+			if len(rule.Index) > 0 {
+				o.Index = fmt.Sprintf("%s-%04d-%02d-%02d", rule.Index, record.Timestamp.Year(), record.Timestamp.Month(), record.Timestamp.Day())
+			}
+			if len(rule.Output) > 0 {
+				output = rule.Output
 			}
-			queue.Put(buf.Bytes())
 		}
-	} else {
-		log.Debug().Str("event", string(raw)).Msg("failed to convert record")
+		if options.Auth.TenantIndex && len(record.Tenant) > 0 {
+			o.Index = strings.ToLower(record.Tenant) + "-" + o.Index
+		}
+		o.Index = p.IndexPrefix + o.Index
+		if err = outputs[output].Put(o); err != nil {
+			log.Error().Err(err).Str("output", output).Str("index", o.Index).Msg("failed to put operation into queue")
+			return
+		}
 	}
+	return
 }
 
 func commandHandlerFunc(conn redcon.Conn, cmd redcon.Command) {
@@ -125,6 +198,27 @@ func commandHandlerFunc(conn redcon.Conn, cmd redcon.Command) {
 	// extract command
 	command := strings.ToLower(string(cmd.Args[0]))
 	log.Debug().Str("addr", conn.RemoteAddr()).Str("cmd", command).Int("args", len(cmd.Args)-1).Msg("new command")
+	// CN of verified client certificate is the authenticated sender, handshake is done once a command is read
+	session := sessionOf(conn.Context())
+	if !session.peerChecked {
+		session.peerChecked = true
+		if cn := peerCommonName(conn.NetConn()); len(cn) > 0 {
+			if session.Tenant = sanitizeTenant(cn); len(session.Tenant) > 0 {
+				log.Info().Str("addr", conn.RemoteAddr()).Str("cn", cn).Str("tenant", session.Tenant).Msg("authenticated with client certificate")
+			} else {
+				log.Warn().Str("addr", conn.RemoteAddr()).Str("cn", cn).Msg("client certificate CN can not be used as tenant")
+			}
+		}
+	}
+	// authentication is required for commands except PING, QUIT and AUTH
+	if authRequired() && len(session.Tenant) == 0 {
+		switch command {
+		case "ping", "quit", "auth":
+		default:
+			conn.WriteError("NOAUTH Authentication required.")
+			return
+		}
+	}
 	// handle command
 	switch command {
 	default:
@@ -135,6 +229,30 @@ func commandHandlerFunc(conn redcon.Conn, cmd redcon.Command) {
 	case "quit":
 		conn.WriteString("OK")
 		conn.Close()
+	case "auth":
+		// AUTH password, or AUTH user password
+		var user, pass string
+		if len(cmd.Args) == 2 {
+			pass = string(cmd.Args[1])
+		} else if len(cmd.Args) == 3 {
+			user, pass = string(cmd.Args[1]), string(cmd.Args[2])
+		} else {
+			conn.WriteError("ERR wrong number of arguments for 'auth' command")
+			return
+		}
+		if !authRequired() {
+			conn.WriteError("ERR Client sent AUTH, but no password is set")
+			return
+		}
+		tenant, ok := authenticate(user, pass)
+		if !ok {
+			log.Warn().Str("addr", conn.RemoteAddr()).Str("user", user).Msg("authentication failed")
+			conn.WriteError("WRONGPASS invalid username-password pair")
+			return
+		}
+		session.Tenant = tenant
+		log.Info().Str("addr", conn.RemoteAddr()).Str("tenant", tenant).Msg("authenticated")
+		conn.WriteString("OK")
 	case "info":
 		if options.Multi {
 			// declare as redis 2.4+, supports multiple values in RPUSH/LPUSH
@@ -149,13 +267,48 @@ func commandHandlerFunc(conn redcon.Conn, cmd redcon.Command) {
 			conn.WriteError("ERR bad command '" + command + "'")
 			return
 		}
+		// key must be mapped to a pipeline
+		key := string(cmd.Args[1])
+		p, ok := resolvePipeline(key)
+		if !ok {
+			conn.WriteError("ERR no pipeline for key '" + key + "'")
+			return
+		}
+		// refuse if queues are above high-water mark
+		if isThrottled() {
+			conn.WriteError("ERR queue is above high-water mark, try again later")
+			return
+		}
 		// retrieve all events
 		for _, raw := range cmd.Args[2:] {
-			consumeRawEvent(raw)
+			if _, err := consumeRawEvent(conn.RemoteAddr(), key, session.Tenant, raw); err != nil {
+				conn.WriteError("ERR failed to persist event: " + err.Error())
+				return
+			}
 		}
-		conn.WriteInt64(queue.Depth())
+		conn.WriteInt64(outputs[p.Output].Queue.Depth())
 	case "llen":
-		conn.WriteInt64(queue.Depth())
+		// at least 2 arguments, LLEN xlog
+		if len(cmd.Args) < 2 {
+			conn.WriteError("ERR bad command '" + command + "'")
+			return
+		}
+		key := string(cmd.Args[1])
+		p, ok := resolvePipeline(key)
+		if !ok {
+			conn.WriteError("ERR no pipeline for key '" + key + "'")
+			return
+		}
+		conn.WriteInt64(outputs[p.Output].Queue.Depth())
+	case "reinject":
+		// re-inject all dead letters
+		reinjected, failed, err := reinjectDeadLetters()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to reinject dead letters")
+			conn.WriteError("ERR " + err.Error())
+			return
+		}
+		conn.WriteString(fmt.Sprintf("reinjected:%d failed:%d", reinjected, failed))
 	}
 }
 
@@ -163,85 +316,6 @@ func closedHandlerFunc(conn redcon.Conn, err error) {
 	log.Info().Err(err).Int64("conns", atomic.AddInt64(&totalConns, -1)).Int("conns-dup", decreaseConnsSum(conn.RemoteAddr())).Str("addr", conn.RemoteAddr()).Msg("connection closed")
 }
 
-func outputRoutine() {
-	shutdownGroup.Add(1)
-	defer shutdownGroup.Done()
-
-	// create the queue read channel
-	records := queue.ReadChan()
-
-	for {
-		// force GC
-		runtime.GC()
-
-		// check for the outputExiting
-		if shutdown {
-			break
-		}
-
-		// c counter
-		var c int
-
-		// build the bulk
-		bs := client.Bulk()
-
-		// timer for 5 seconds
-		timer := time.NewTimer(time.Second * 3)
-
-	FOR_LOOP:
-		for {
-			select {
-			case buf := <-records:
-				{
-					// decode operation
-					var o Operation
-					if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&o); err != nil {
-						continue FOR_LOOP
-					}
-					// increase counter
-					c++
-					// increase total counter
-					atomic.AddInt64(&totalCount, 1)
-					// create request
-					br := elastic.NewBulkIndexRequest().Index(o.Index).Type("_doc").Doc(string(o.Body))
-					log.Debug().Msg("new bulk request:\n" + br.String())
-					// append request to bulk
-					bs = bs.Add(br)
-					// break the loop if batch size exceeded
-					if c > options.Elasticsearch.Batch.Size {
-						log.Debug().Msg("batch size exceeded")
-						break FOR_LOOP
-					}
-				}
-			case <-timer.C:
-				{
-					// break the loop if timeout exceeded
-					log.Debug().Msg("batch timeout exceeded")
-					break FOR_LOOP
-				}
-			}
-		}
-
-		// clear the timer
-		timer.Stop()
-
-		// continue if no records
-		if c == 0 {
-			continue
-		}
-
-		// do the bulk operation
-		if _, err := bs.Do(context.Background()); err != nil {
-			time.Sleep(500 * time.Millisecond)
-			log.Info().Err(err).Msg("failed to bulk insert")
-		}
-		log.Debug().Msg("bulk committed")
-
-		// slow down loop with limiter
-		limiter.Wait(int64(c))
-	}
-}
-
 func statsRoutine() {
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
@@ -251,16 +325,28 @@ func statsRoutine() {
 		count := totalCount
 		// wait for next tick
 		<-ticker.C
+		// collect dead letter counts
+		deadTotal, deadReasons := deadLetterCounts()
 		// create stats
 		r := Stats{
-			Timestamp:     time.Now(),
-			Hostname:      hostname,
-			RecordsTotal:  totalCount,
-			Records1M:     totalCount - count,
-			RecordsQueued: queue.Depth(),
+			Timestamp:      time.Now(),
+			Hostname:       hostname,
+			RecordsTotal:   totalCount,
+			Records1M:      totalCount - count,
+			RecordsQueued:  totalDepth(),
+			RecordsFailed:  atomic.LoadInt64(&totalFailed),
+			RecordsDead:    deadTotal,
+			DeadLetters:    deadReasons,
+			Throttled:      isThrottled(),
+			ThrottleEvents: atomic.LoadInt64(&throttleEvents),
+			SampledOut:     atomic.LoadInt64(&sampledOut),
+			Redactions:     redactionCounts(),
+			UnknownHosts:   takeUnknownHosts(),
+			RecordsDup:     atomic.LoadInt64(&totalDup),
+			SyslogDropped:  atomic.LoadInt64(&syslogDropped),
 		}
 		// insert stats
-		if _, err := client.Index().Index(r.Index()).Type("_doc").BodyJson(&r).Do(context.Background()); err != nil {
+		if _, err := outputs[defaultOutput].Client.Index().Index(r.Index()).Type("_doc").BodyJson(&r).Do(context.Background()); err != nil {
 			log.Error().Err(err).Msg("failed to write stats")
 		} else {
 			log.Info().Interface("stats", &r).Msg("stats collected")
@@ -316,24 +402,60 @@ func main() {
 		return
 	}
 
-	// create the queue
-	queue = diskqueue.New("xlogd", options.DataDir, 256*1024*1024, 20, 2*1024*1024, int64(options.Elasticsearch.Batch.Size), time.Second*20)
+	// create the failure store
+	if failures, err = NewJournal(options.FailureDir, "failed"); err != nil {
+		log.Error().Err(err).Msg("failed to ensure xlog failure dir")
+		os.Exit(1)
+		return
+	}
 
-	// create elasticsearch client
-	if client, err = elastic.NewClient(elastic.SetURL(options.Elasticsearch.URLs...)); err != nil {
-		log.Error().Err(err).Msg("failed to create elasticsearch client")
+	// create the dead letter store
+	if deadLetters, err = NewJournal(options.DeadLetterDir, "dead"); err != nil {
+		log.Error().Err(err).Msg("failed to ensure xlog dead letter dir")
 		os.Exit(1)
 		return
 	}
 
-	// initialize limiter
-	limiter = ratelimit.NewBucket(
-		time.Second/time.Duration(options.Elasticsearch.Batch.Rate),
-		int64(options.Elasticsearch.Batch.Burst),
-	)
+	// load the geoip databases
+	if err = loadGeoIP(); err != nil {
+		log.Error().Err(err).Msg("failed to load geoip database")
+		os.Exit(1)
+		return
+	}
+
+	// load the host inventory
+	if err = loadInventory(); err != nil {
+		log.Error().Err(err).Msg("failed to load inventory")
+		os.Exit(1)
+		return
+	}
+
+	// create the outputs
+	if outputs[defaultOutput], err = NewOutput(defaultOutput, options.Elasticsearch, options.DataDir); err != nil {
+		log.Error().Err(err).Msg("failed to create elasticsearch client")
+		os.Exit(1)
+		return
+	}
+	for name, opts := range options.Outputs {
+		if outputs[name], err = NewOutput(name, opts, options.DataDir); err != nil {
+			log.Error().Err(err).Str("output", name).Msg("failed to create elasticsearch client")
+			os.Exit(1)
+			return
+		}
+	}
 
 	// create server
-	server = redcon.NewServer(options.Bind, commandHandlerFunc, acceptHandlerFunc, closedHandlerFunc)
+	if len(options.TLS.Cert) > 0 {
+		var cfg *tls.Config
+		if cfg, err = buildTLSConfig(options.TLS); err != nil {
+			log.Error().Err(err).Msg("failed to load tls options")
+			os.Exit(1)
+			return
+		}
+		server = redcon.NewServerTLS(options.Bind, commandHandlerFunc, acceptHandlerFunc, closedHandlerFunc, cfg)
+	} else {
+		server = redcon.NewServer(options.Bind, commandHandlerFunc, acceptHandlerFunc, closedHandlerFunc)
+	}
 
 	// start the server
 	setup := make(chan error, 1)
@@ -343,10 +465,51 @@ func main() {
 		os.Exit(1)
 		return
 	}
-	log.Info().Str("bind", options.Bind).Str("version", Version).Msg("server started")
+	log.Info().Str("bind", options.Bind).Bool("tls", len(options.TLS.Cert) > 0).Str("version", Version).Msg("server started")
+
+	// start the http server
+	if len(options.HTTP.Bind) > 0 {
+		var l net.Listener
+		if l, err = net.Listen("tcp", options.HTTP.Bind); err != nil {
+			log.Error().Err(err).Msg("failed to start http server")
+			os.Exit(1)
+			return
+		}
+		httpServer = &http.Server{Handler: http.HandlerFunc(httpHandlerFunc)}
+		go httpServer.Serve(l)
+		log.Info().Str("bind", options.HTTP.Bind).Msg("http server started")
+	}
+
+	// start the syslog servers
+	if err = startSyslog(); err != nil {
+		log.Error().Err(err).Msg("failed to start syslog server")
+		os.Exit(1)
+		return
+	}
+
+	// start the lumberjack server
+	if err = startLumberjack(); err != nil {
+		log.Error().Err(err).Msg("failed to start lumberjack server")
+		os.Exit(1)
+		return
+	}
+
+	// start pulling from upstream redis
+	startPull()
+
+	// start output routines
+	for _, o := range outputs {
+		go o.Routine()
+	}
+
+	// start geoIPRoutine
+	go geoIPRoutine()
+
+	// start inventoryRoutine
+	go inventoryRoutine()
 
-	// start outputRoutine
-	go outputRoutine()
+	// start backpressureRoutine
+	go backpressureRoutine()
 
 	// start statsRoutine
 	go statsRoutine()
@@ -358,12 +521,33 @@ func main() {
 	err = server.Close()
 	log.Info().Str("bind", options.Bind).Err(err).Msg("server closed")
 
+	// close the http server
+	if httpServer != nil {
+		err = httpServer.Shutdown(context.Background())
+		log.Info().Str("bind", options.HTTP.Bind).Err(err).Msg("http server closed")
+	}
+
+	// close the syslog servers
+	stopSyslog()
+
+	// close the lumberjack server
+	stopLumberjack()
+
+	// stop pulling from upstream redis
+	stopPull()
+
 	// mark to shutdown and wait for output complete
 	shutdown = true
 	shutdownGroup.Wait()
 	log.Info().Msg("output queue drained")
 
-	// close the queue
-	queue.Close()
+	// close the failure store and dead letter store
+	failures.Close()
+	deadLetters.Close()
+
+	// close the queues
+	for _, o := range outputs {
+		o.Queue.Close()
+	}
 	log.Info().Msg("queue file closed, exiting")
 }
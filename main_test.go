@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolvePipeline(t *testing.T) {
+	options = Options{TimeOffset: -8}
+	p, ok := resolvePipeline("anything")
+	if !ok || p.Output != defaultOutput || *p.TimeOffset != -8 {
+		t.Fatal("default pipeline")
+	}
+	offset := 0
+	options.Pipelines = []PipelineOptions{
+		{Key: "xlog", Output: defaultOutput, TimeOffset: &options.TimeOffset},
+		{Key: "audit-*", IndexPrefix: "audit-", Output: "archive", TimeOffset: &offset},
+	}
+	defer func() {
+		options = Options{}
+	}()
+	if p, ok = resolvePipeline("audit-web"); !ok || p.Output != "archive" || p.IndexPrefix != "audit-" {
+		t.Fatal("glob pipeline")
+	}
+	if _, ok = resolvePipeline("unknown"); ok {
+		t.Fatal("unknown key")
+	}
+}
+
+func TestEnqueueRecord(t *testing.T) {
+	q, cheap := &memoryQueue{}, &memoryQueue{}
+	outputs = map[string]*Output{defaultOutput: {Name: defaultOutput, Queue: q}, "cheap": {Name: "cheap", Queue: cheap}}
+	options = Options{
+		Ignore: []string{"debug"},
+		Rules: []RuleOptions{
+			{Topic: "access", Message: "/health", Action: RuleDrop},
+			{Topic: "debug", Env: "dev", Action: RuleKeep},
+			{Env: "staging", Action: RuleRoute, Index: "staging-all", Output: "cheap"},
+		},
+	}
+	for i := range options.Rules {
+		if err := options.Rules[i].compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		options = Options{}
+		outputs = map[string]*Output{}
+	}()
+	p, _ := resolvePipeline("xlog")
+	ts := time.Date(2018, time.April, 11, 23, 23, 13, 0, time.UTC)
+	enqueueRecord(p, Record{Timestamp: ts, Topic: "access", Env: "prod", Message: "GET /health"})
+	enqueueRecord(p, Record{Timestamp: ts, Topic: "debug", Env: "prod"})
+	if q.Depth() != 0 {
+		t.Fatal("should drop")
+	}
+	enqueueRecord(p, Record{Timestamp: ts, Topic: "debug", Env: "dev"})
+	enqueueRecord(p, Record{Timestamp: ts, Topic: "access", Env: "prod", Message: "GET /"})
+	if q.Depth() != 2 {
+		t.Fatal("should keep", q.Depth())
+	}
+	enqueueRecord(p, Record{Timestamp: ts, Topic: "access", Env: "staging"})
+	enqueueRecord(p, Record{Timestamp: ts, Topic: "debug", Env: "staging"})
+	if cheap.Depth() != 1 {
+		t.Fatal("should route")
+	}
+	o, err := DecodeOperation(cheap.items[0])
+	if err != nil || o.Index != "staging-all-2018-04-11" {
+		t.Fatal("routed index", o.Index, err)
+	}
+}
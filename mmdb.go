@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net"
+)
+
+var (
+	mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+	errMMDBCorrupted = errors.New("mmdb: corrupted data")
+)
+
+const (
+	mmdbMaxDepth = 32
+)
+
+// mmdbReader reader of MaxMind DB files, https://maxmind.github.io/MaxMind-DB/
+type mmdbReader struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+// openMMDB read and parse a MaxMind DB file
+func openMMDB(file string) (*mmdbReader, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return newMMDBReader(buf)
+}
+
+// newMMDBReader parse MaxMind DB from buf
+func newMMDBReader(buf []byte) (r *mmdbReader, err error) {
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i < 0 {
+		err = errors.New("mmdb: metadata not found")
+		return
+	}
+	var v interface{}
+	if v, _, err = mmdbDecode(buf[i+len(mmdbMetadataMarker):], 0, 0); err != nil {
+		return
+	}
+	m, _ := v.(map[string]interface{})
+	r = &mmdbReader{
+		nodeCount:  mmdbUint(m["node_count"]),
+		recordSize: mmdbUint(m["record_size"]),
+		ipVersion:  mmdbUint(m["ip_version"]),
+	}
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		err = errors.New("mmdb: unsupported record size")
+		return
+	}
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+16 > uint(i) {
+		err = errMMDBCorrupted
+		return
+	}
+	r.tree = buf[:treeSize]
+	r.data = buf[treeSize+16 : i]
+	// IPv4 addresses are stored as ::a.b.c.d in IPv6 databases
+	if r.ipVersion == 6 {
+		for j := 0; j < 96 && r.ipv4Start < r.nodeCount; j++ {
+			r.ipv4Start = r.readNode(r.ipv4Start, 0)
+		}
+	}
+	return
+}
+
+// readNode read the left (bit 0) or right (bit 1) record of node
+func (r *mmdbReader) readNode(node uint, bit uint) uint {
+	b := r.tree
+	switch r.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+	case 28:
+		off := node * 7
+		if bit == 0 {
+			return (uint(b[off+3])&0xF0)<<20 | uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+		}
+		return (uint(b[off+3])&0x0F)<<24 | uint(b[off+4])<<16 | uint(b[off+5])<<8 | uint(b[off+6])
+	default:
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(b[off:]))
+	}
+}
+
+// Lookup find the data of network containing ip, ok is false if not found
+func (r *mmdbReader) Lookup(ip net.IP) (v interface{}, ok bool, err error) {
+	var bits int
+	var node uint
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits, node = ip4, 32, r.ipv4Start
+	} else if ip = ip.To16(); ip != nil && r.ipVersion == 6 {
+		bits = 128
+	} else {
+		return
+	}
+	for i := 0; i < bits && node < r.nodeCount; i++ {
+		node = r.readNode(node, uint(ip[i>>3]>>(7-uint(i&7)))&1)
+	}
+	if node <= r.nodeCount {
+		return
+	}
+	if v, _, err = mmdbDecode(r.data, node-r.nodeCount-16, 0); err == nil {
+		ok = true
+	}
+	return
+}
+
+// mmdbDecode decode a value of data section at offset, returns offset of the next value
+func mmdbDecode(buf []byte, offset uint, depth int) (v interface{}, next uint, err error) {
+	if depth > mmdbMaxDepth || offset >= uint(len(buf)) {
+		err = errMMDBCorrupted
+		return
+	}
+	ctrl := buf[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+	// pointer
+	if typ == 1 {
+		n := uint(ctrl>>3)&0x3 + 1
+		if offset+n > uint(len(buf)) {
+			err = errMMDBCorrupted
+			return
+		}
+		p := uint(ctrl & 0x7)
+		for _, c := range buf[offset : offset+n] {
+			p = p<<8 | uint(c)
+		}
+		switch n {
+		case 2:
+			p += 2048
+		case 3:
+			p += 526336
+		case 4:
+			p = uint(binary.BigEndian.Uint32(buf[offset:]))
+		}
+		v, _, err = mmdbDecode(buf, p, depth+1)
+		next = offset + n
+		return
+	}
+	// extended type
+	if typ == 0 {
+		if offset >= uint(len(buf)) {
+			err = errMMDBCorrupted
+			return
+		}
+		typ = 7 + uint(buf[offset])
+		offset++
+	}
+	// size
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(buf)) {
+			err = errMMDBCorrupted
+			return
+		}
+		var s uint
+		for _, c := range buf[offset : offset+n] {
+			s = s<<8 | uint(c)
+		}
+		offset += n
+		size = s + []uint{29, 285, 65821}[n-1]
+	}
+	// maps and arrays contain values, others occupy size bytes
+	switch typ {
+	case 7:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			var key, val interface{}
+			if key, offset, err = mmdbDecode(buf, offset, depth+1); err != nil {
+				return
+			}
+			if val, offset, err = mmdbDecode(buf, offset, depth+1); err != nil {
+				return
+			}
+			k, _ := key.(string)
+			m[k] = val
+		}
+		v, next = m, offset
+		return
+	case 11:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			var val interface{}
+			if val, offset, err = mmdbDecode(buf, offset, depth+1); err != nil {
+				return
+			}
+			a = append(a, val)
+		}
+		v, next = a, offset
+		return
+	case 14:
+		v, next = size != 0, offset
+		return
+	}
+	if offset+size > uint(len(buf)) {
+		err = errMMDBCorrupted
+		return
+	}
+	b := buf[offset : offset+size]
+	next = offset + size
+	switch typ {
+	case 2:
+		v = string(b)
+	case 3:
+		if size != 8 {
+			err = errMMDBCorrupted
+			return
+		}
+		v = math.Float64frombits(binary.BigEndian.Uint64(b))
+	case 15:
+		if size != 4 {
+			err = errMMDBCorrupted
+			return
+		}
+		v = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case 5, 6, 9, 10:
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		v = n
+	case 8:
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		v = int64(int32(n))
+	case 4:
+		v = append([]byte{}, b...)
+	}
+	return
+}
+
+// mmdbUint convert decoded unsigned integer
+func mmdbUint(v interface{}) uint {
+	n, _ := v.(uint64)
+	return uint(n)
+}
+
+// mmdbPath walk decoded maps by keys
+func mmdbPath(v interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
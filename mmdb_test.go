@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"sort"
+	"testing"
+)
+
+// mmdbTestCtrl control bytes of type and size, types above 7 are not supported
+func mmdbTestCtrl(typ int, size int) []byte {
+	if size < 29 {
+		return []byte{byte(typ<<5 | size)}
+	}
+	return []byte{byte(typ<<5 | 29), byte(size - 29)}
+}
+
+// mmdbTestEncode minimal encoder of test databases
+func mmdbTestEncode(v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		return append(mmdbTestCtrl(2, len(v)), v...)
+	case uint32:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, v)
+		return append(mmdbTestCtrl(6, 4), b...)
+	case float64:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(v))
+		return append(mmdbTestCtrl(3, 8), b...)
+	case map[string]interface{}:
+		out := mmdbTestCtrl(7, len(v))
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			out = append(out, mmdbTestEncode(k)...)
+			out = append(out, mmdbTestEncode(v[k])...)
+		}
+		return out
+	}
+	panic("unsupported")
+}
+
+// buildTestMMDB build a database with networks, IPv4 networks are stored as ::a.b.c.d in IPv6 databases
+func buildTestMMDB(ipVersion int, recordSize int, networks map[string]map[string]interface{}) []byte {
+	type node [2]int
+	nodes := []node{{-1, -1}}
+	var data []byte
+	var offsets []int
+	for cidr, value := range networks {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		ip, ones := []byte(n.IP), 0
+		ones, _ = n.Mask.Size()
+		if ipVersion == 6 && len(ip) == 4 {
+			ip = append(make([]byte, 12), ip...)
+			ones += 96
+		}
+		offsets = append(offsets, len(data))
+		data = append(data, mmdbTestEncode(value)...)
+		cur := 0
+		for i := 0; i < ones; i++ {
+			bit := int(ip[i>>3]>>(7-uint(i&7))) & 1
+			if i == ones-1 {
+				nodes[cur][bit] = -2 - (len(offsets) - 1)
+				break
+			}
+			if nodes[cur][bit] < 0 {
+				nodes = append(nodes, node{-1, -1})
+				nodes[cur][bit] = len(nodes) - 1
+			}
+			cur = nodes[cur][bit]
+		}
+	}
+	count := len(nodes)
+	var tree []byte
+	for _, n := range nodes {
+		var rs [2]uint32
+		for i, v := range n {
+			switch {
+			case v == -1:
+				rs[i] = uint32(count)
+			case v <= -2:
+				rs[i] = uint32(count + 16 + offsets[-2-v])
+			default:
+				rs[i] = uint32(v)
+			}
+		}
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(rs[0]>>16), byte(rs[0]>>8), byte(rs[0]), byte(rs[1]>>16), byte(rs[1]>>8), byte(rs[1]))
+		case 28:
+			tree = append(tree, byte(rs[0]>>16), byte(rs[0]>>8), byte(rs[0]), byte(rs[0]>>24<<4|rs[1]>>24), byte(rs[1]>>16), byte(rs[1]>>8), byte(rs[1]))
+		case 32:
+			tree = append(tree, byte(rs[0]>>24), byte(rs[0]>>16), byte(rs[0]>>8), byte(rs[0]), byte(rs[1]>>24), byte(rs[1]>>16), byte(rs[1]>>8), byte(rs[1]))
+		}
+	}
+	out := append(tree, make([]byte, 16)...)
+	out = append(out, data...)
+	out = append(out, mmdbMetadataMarker...)
+	out = append(out, mmdbTestEncode(map[string]interface{}{
+		"node_count":    uint32(count),
+		"record_size":   uint32(recordSize),
+		"ip_version":    uint32(ipVersion),
+		"database_type": "Test",
+	})...)
+	return out
+}
+
+var testGeoNetworks = map[string]map[string]interface{}{
+	"1.2.3.0/24": {
+		"country":  map[string]interface{}{"iso_code": "CN"},
+		"city":     map[string]interface{}{"names": map[string]interface{}{"en": "Shanghai"}},
+		"location": map[string]interface{}{"latitude": 31.2222, "longitude": 121.4581},
+	},
+	"8.8.0.0/16": {
+		"country": map[string]interface{}{"iso_code": "US"},
+	},
+}
+
+func TestMMDBReader_Lookup(t *testing.T) {
+	for _, ipVersion := range []int{4, 6} {
+		for _, recordSize := range []int{24, 28, 32} {
+			r, err := newMMDBReader(buildTestMMDB(ipVersion, recordSize, testGeoNetworks))
+			if err != nil {
+				t.Fatal(ipVersion, recordSize, err)
+			}
+			v, ok, err := r.Lookup(net.ParseIP("1.2.3.4"))
+			if err != nil || !ok {
+				t.Fatal(ipVersion, recordSize, "lookup", err)
+			}
+			if mmdbPath(v, "city", "names", "en") != "Shanghai" || mmdbPath(v, "location", "latitude") != 31.2222 {
+				t.Fatal(ipVersion, recordSize, "value", v)
+			}
+			if v, ok, _ = r.Lookup(net.ParseIP("8.8.8.8")); !ok || mmdbPath(v, "country", "iso_code") != "US" {
+				t.Fatal(ipVersion, recordSize, "second network", v)
+			}
+			if _, ok, _ = r.Lookup(net.ParseIP("1.2.4.1")); ok {
+				t.Fatal(ipVersion, recordSize, "should not be found")
+			}
+			if _, ok, _ = r.Lookup(net.ParseIP("2001:db8::1")); ok {
+				t.Fatal(ipVersion, recordSize, "ipv6 should not be found")
+			}
+		}
+	}
+	if _, err := newMMDBReader([]byte("garbage")); err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestMMDBDecode_Pointer(t *testing.T) {
+	// a map whose value is a pointer to the string at offset 0
+	buf := append(mmdbTestEncode("hello"), mmdbTestCtrl(7, 1)...)
+	buf = append(buf, mmdbTestEncode("k")...)
+	buf = append(buf, 1<<5, 0)
+	v, next, err := mmdbDecode(buf, 6, 0)
+	if err != nil || next != uint(len(buf)) || mmdbPath(v, "k") != "hello" {
+		t.Fatal("pointer", v, next, err)
+	}
+	// pointer to itself
+	if _, _, err = mmdbDecode([]byte{1 << 5, 0}, 0, 0); err == nil {
+		t.Fatal("should fail")
+	}
+}
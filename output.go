@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/ratelimit"
+	"github.com/olivere/elastic"
+	"github.com/rs/zerolog/log"
+	"github.com/yankeguo/diskqueue"
+)
+
+const (
+	defaultOutput = "default"
+)
+
+// Output elasticsearch output with its own disk queue
+type Output struct {
+	Name    string
+	Options ElasticsearchOptions
+	Client  *elastic.Client
+	Queue   diskqueue.DiskQueue
+	Limiter *ratelimit.Bucket
+}
+
+// NewOutput create elasticsearch client, disk queue and limiter of a output
+func NewOutput(name string, opts ElasticsearchOptions, dataDir string) (o *Output, err error) {
+	o = &Output{Name: name, Options: opts}
+	// create elasticsearch client
+	if o.Client, err = elastic.NewClient(elastic.SetURL(opts.URLs...)); err != nil {
+		return
+	}
+	// create the queue, queue of default output keeps the legacy name
+	queueName := "xlogd"
+	if name != defaultOutput {
+		queueName = "xlogd-" + name
+	}
+	o.Queue = diskqueue.New(queueName, dataDir, 256*1024*1024, 20, 2*1024*1024, int64(opts.Batch.Size), time.Second*20)
+	// initialize limiter
+	o.Limiter = ratelimit.NewBucket(
+		time.Second/time.Duration(opts.Batch.Rate),
+		int64(opts.Batch.Burst),
+	)
+	return
+}
+
+// Put encode operation and put into queue
+func (o *Output) Put(op Operation) (err error) {
+	var buf []byte
+	if buf, err = op.Encode(); err != nil {
+		return
+	}
+	return o.Queue.Put(buf)
+}
+
+func (o *Output) requeue(ops []Operation) {
+	for _, op := range ops {
+		if err := o.Put(op); err != nil {
+			log.Error().Err(err).Str("output", o.Name).Str("index", op.Index).Msg("failed to requeue operation")
+		}
+	}
+	log.Info().Str("output", o.Name).Int("count", len(ops)).Msg("pending operations put back to queue")
+}
+
+// commit bulk insert operations, retries until all operations are committed or permanently rejected
+func (o *Output) commit(ops []Operation) {
+	backoff := time.Millisecond * time.Duration(o.Options.Retry.MinBackoff)
+	maxBackoff := time.Millisecond * time.Duration(o.Options.Retry.MaxBackoff)
+
+	for {
+		// build the bulk
+		bs := o.Client.Bulk()
+		for _, op := range ops {
+			br := elastic.NewBulkIndexRequest().Index(op.Index).Type("_doc").Doc(string(op.Body))
+			// create with id, replays are rejected as conflicts
+			if len(op.ID) > 0 {
+				br = br.OpType("create").Id(op.ID)
+			}
+			log.Debug().Msg("new bulk request:\n" + br.String())
+			bs = bs.Add(br)
+		}
+
+		// do the bulk operation
+		if res, err := bs.Do(context.Background()); err != nil {
+			// whole bulk permanently rejected as bad or too large request, others like auth or proxy errors are retried
+			if e, ok := err.(*elastic.Error); ok && (e.Status == 400 || e.Status == 413) {
+				log.Error().Err(err).Str("output", o.Name).Int("count", len(ops)).Msg("bulk rejected")
+				for _, op := range ops {
+					saveFailedOperation(op, &elastic.BulkResponseItem{Status: e.Status, Error: e.Details})
+				}
+				return
+			}
+			log.Warn().Err(err).Str("output", o.Name).Int("count", len(ops)).Dur("backoff", backoff).Msg("failed to bulk insert, will retry")
+		} else {
+			// collect retryable items, save permanently rejected items
+			var retries []Operation
+			for i := range ops {
+				// no response item, not known to be committed
+				if i >= len(res.Items) {
+					retries = append(retries, ops[i])
+					continue
+				}
+				for _, ri := range res.Items[i] {
+					if ri.Status >= 200 && ri.Status < 300 {
+						continue
+					}
+					// document already exists, a duplicate
+					if ri.Status == 409 && len(ops[i].ID) > 0 {
+						atomic.AddInt64(&totalDup, 1)
+						continue
+					}
+					if isRetryableStatus(ri.Status) {
+						retries = append(retries, ops[i])
+					} else {
+						saveFailedOperation(ops[i], ri)
+					}
+				}
+			}
+			if len(retries) == 0 {
+				log.Debug().Str("output", o.Name).Msg("bulk committed")
+				return
+			}
+			log.Warn().Str("output", o.Name).Int("count", len(retries)).Dur("backoff", backoff).Msg("bulk partially failed, will retry")
+			ops = retries
+		}
+
+		// put pending operations back to queue if shutting down
+		if shutdown {
+			o.requeue(ops)
+			return
+		}
+
+		// exponential backoff
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// Routine read operations from queue and commit them in batches
+func (o *Output) Routine() {
+	shutdownGroup.Add(1)
+	defer shutdownGroup.Done()
+
+	// create the queue read channel
+	records := o.Queue.ReadChan()
+
+	for {
+		// force GC
+		runtime.GC()
+
+		// check for the outputExiting
+		if shutdown {
+			break
+		}
+
+		// operations of this batch
+		var ops []Operation
+
+		// timer for 5 seconds
+		timer := time.NewTimer(time.Second * 3)
+
+	FOR_LOOP:
+		for {
+			select {
+			case buf := <-records:
+				{
+					// decode operation
+					op, err := DecodeOperation(buf)
+					if err != nil {
+						continue FOR_LOOP
+					}
+					// increase total counter
+					atomic.AddInt64(&totalCount, 1)
+					// append operation to batch
+					ops = append(ops, op)
+					// break the loop if batch size exceeded
+					if len(ops) > o.Options.Batch.Size {
+						log.Debug().Str("output", o.Name).Msg("batch size exceeded")
+						break FOR_LOOP
+					}
+				}
+			case <-timer.C:
+				{
+					// break the loop if timeout exceeded
+					log.Debug().Str("output", o.Name).Msg("batch timeout exceeded")
+					break FOR_LOOP
+				}
+			}
+		}
+
+		// clear the timer
+		timer.Stop()
+
+		// continue if no records
+		if len(ops) == 0 {
+			continue
+		}
+
+		// commit the operations
+		o.commit(ops)
+
+		// slow down loop with limiter
+		o.Limiter.Wait(int64(len(ops)))
+	}
+}
+
+func isRetryableStatus(status int) bool {
+	switch status {
+	case 429, 502, 503, 504:
+		return true
+	}
+	return false
+}
+
+func saveFailedOperation(o Operation, item *elastic.BulkResponseItem) {
+	atomic.AddInt64(&totalFailed, 1)
+	f := FailedOperation{
+		Timestamp: time.Now(),
+		Index:     o.Index,
+		Status:    item.Status,
+		Body:      string(o.Body),
+	}
+	if item.Error != nil {
+		f.Error = item.Error.Type + ": " + item.Error.Reason
+	}
+	if err := failures.Append(f); err != nil {
+		log.Error().Err(err).Str("index", o.Index).Msg("failed to save failed operation")
+	}
+}
+
+// totalDepth depth of all output queues
+func totalDepth() (depth int64) {
+	for _, o := range outputs {
+		depth += o.Queue.Depth()
+	}
+	return
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/olivere/elastic"
+)
+
+func TestOutput_CommitCreate(t *testing.T) {
+	var actions []map[string]map[string]interface{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var items []string
+		sc := bufio.NewScanner(req.Body)
+		for i := 0; sc.Scan(); i++ {
+			if i%2 == 1 {
+				continue
+			}
+			var action map[string]map[string]interface{}
+			json.Unmarshal(sc.Bytes(), &action)
+			actions = append(actions, action)
+			for op := range action {
+				// the second document exists
+				status := 201
+				if len(actions) == 2 {
+					status = 409
+				}
+				items = append(items, fmt.Sprintf(`{"%s":{"_index":"test","status":%d}}`, op, status))
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"took":1,"errors":true,"items":[%s]}`, strings.Join(items, ","))
+	}))
+	defer s.Close()
+	client, err := elastic.NewClient(elastic.SetURL(s.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &Output{Name: defaultOutput, Client: client}
+	r := Record{Hostname: "test", Message: "hello"}
+	before := totalDup
+	o.commit([]Operation{
+		{Index: "test", ID: r.DocumentID(nil), Body: []byte(`{}`)},
+		{Index: "test", ID: r.DocumentID(nil), Body: []byte(`{}`)},
+		{Index: "test", Body: []byte(`{}`)},
+	})
+	if totalDup != before+1 {
+		t.Fatal("duplicate should be counted")
+	}
+	if len(actions) != 3 || actions[0]["create"]["_id"] != r.DocumentID(nil) || actions[2]["index"] == nil {
+		t.Fatal("actions", actions)
+	}
+}
+
+// newTestBulkOutput output backed by a fake elasticsearch, respond returns status and items of the n-th bulk request
+// with given number of actions, items are joined as bulk response if status is 200
+func newTestBulkOutput(t *testing.T, respond func(n int, count int) (int, []string)) (o *Output, calls *int, done func()) {
+	calls = new(int)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var count int
+		sc := bufio.NewScanner(req.Body)
+		for i := 0; sc.Scan(); i++ {
+			if i%2 == 0 {
+				count++
+			}
+		}
+		*calls++
+		status, items := respond(*calls, count)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status == 200 {
+			fmt.Fprintf(w, `{"took":1,"errors":true,"items":[%s]}`, strings.Join(items, ","))
+		}
+	}))
+	dir, err := ioutil.TempDir("", "xlogd-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures, err = NewJournal(dir, "failed"); err != nil {
+		t.Fatal(err)
+	}
+	client, err := elastic.NewClient(elastic.SetURL(s.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o = &Output{Name: defaultOutput, Client: client, Queue: &memoryQueue{}}
+	done = func() {
+		s.Close()
+		failures.Close()
+		failures = nil
+		os.RemoveAll(dir)
+	}
+	return
+}
+
+func testBulkItems(statuses ...int) (items []string) {
+	for _, status := range statuses {
+		items = append(items, fmt.Sprintf(`{"index":{"_index":"test","status":%d}}`, status))
+	}
+	return
+}
+
+func TestOutput_CommitRetry(t *testing.T) {
+	ops := []Operation{{Index: "test", Body: []byte(`{}`)}, {Index: "test", Body: []byte(`{}`)}, {Index: "test", Body: []byte(`{}`)}}
+
+	// partial failure, only the throttled item is retried
+	var counts []int
+	o, calls, done := newTestBulkOutput(t, func(n int, count int) (int, []string) {
+		counts = append(counts, count)
+		if n == 1 {
+			return 200, testBulkItems(201, 429, 201)
+		}
+		return 200, testBulkItems(201)
+	})
+	failed := atomic.LoadInt64(&totalFailed)
+	o.commit(ops)
+	done()
+	if *calls != 2 || counts[1] != 1 || atomic.LoadInt64(&totalFailed) != failed {
+		t.Fatal("partial failure", *calls, counts)
+	}
+
+	// missing response items are retried, not treated as committed
+	counts = nil
+	o, calls, done = newTestBulkOutput(t, func(n int, count int) (int, []string) {
+		counts = append(counts, count)
+		if n == 1 {
+			return 200, testBulkItems(201)
+		}
+		return 200, testBulkItems(201, 201)
+	})
+	o.commit(ops)
+	done()
+	if *calls != 2 || counts[1] != 2 {
+		t.Fatal("missing items", *calls, counts)
+	}
+}
+
+func TestOutput_CommitReject(t *testing.T) {
+	ops := []Operation{{Index: "test", Body: []byte(`{}`)}, {Index: "test", Body: []byte(`{}`)}}
+
+	// permanently rejected item goes to failure store
+	o, calls, done := newTestBulkOutput(t, func(n int, count int) (int, []string) {
+		return 200, testBulkItems(201, 400)
+	})
+	failed := atomic.LoadInt64(&totalFailed)
+	o.commit(ops)
+	done()
+	if *calls != 1 || atomic.LoadInt64(&totalFailed) != failed+1 {
+		t.Fatal("rejected item", *calls)
+	}
+
+	// whole bulk rejected, all operations go to failure store
+	o, calls, done = newTestBulkOutput(t, func(n int, count int) (int, []string) {
+		return 413, nil
+	})
+	failed = atomic.LoadInt64(&totalFailed)
+	o.commit(ops)
+	files, _ := failures.Detach()
+	done()
+	if *calls != 1 || atomic.LoadInt64(&totalFailed) != failed+2 || len(files) != 1 {
+		t.Fatal("rejected bulk", *calls, files)
+	}
+
+	// auth or proxy errors of whole bulk are retried
+	o, calls, done = newTestBulkOutput(t, func(n int, count int) (int, []string) {
+		switch n {
+		case 1:
+			return 401, nil
+		case 2:
+			return 500, nil
+		}
+		return 200, testBulkItems(201, 201)
+	})
+	failed = atomic.LoadInt64(&totalFailed)
+	o.commit(ops)
+	done()
+	if *calls != 3 || atomic.LoadInt64(&totalFailed) != failed {
+		t.Fatal("retried bulk", *calls)
+	}
+}
+
+func TestOutput_CommitShutdown(t *testing.T) {
+	defer func() {
+		shutdown = false
+	}()
+	o, calls, done := newTestBulkOutput(t, func(n int, count int) (int, []string) {
+		return 200, testBulkItems(201, 503)
+	})
+	defer done()
+	shutdown = true
+	o.commit([]Operation{{Index: "test", Body: []byte(`{}`)}, {Index: "test", Body: []byte(`{"pending":true}`)}})
+	q := o.Queue.(*memoryQueue)
+	if *calls != 1 || q.Depth() != 1 {
+		t.Fatal("requeue", *calls, q.Depth())
+	}
+	if op, err := DecodeOperation(q.items[0]); err != nil || string(op.Body) != `{"pending":true}` {
+		t.Fatal("requeued", string(op.Body), err)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Processor transforms a record into zero or more records
+type Processor interface {
+	Process(r Record) []Record
+}
+
+// NewProcessor create a processor from options
+func NewProcessor(opts ProcessorOptions) (p Processor, err error) {
+	var re *regexp.Regexp
+	if len(opts.Pattern) > 0 {
+		if re, err = regexp.Compile(opts.Pattern); err != nil {
+			return
+		}
+	}
+	field := opts.Field
+	if len(field) == 0 {
+		field = "message"
+	}
+	switch opts.Type {
+	case "drop_if":
+		if re == nil {
+			err = errors.New("processor drop_if: pattern is required")
+			return
+		}
+		p = dropIfProcessor{field: field, regexp: re}
+	case "rename":
+		if len(opts.Field) == 0 || len(opts.Target) == 0 {
+			err = errors.New("processor rename: field and target are required")
+			return
+		}
+		p = renameProcessor{field: opts.Field, target: opts.Target}
+	case "set":
+		if len(opts.Field) == 0 {
+			err = errors.New("processor set: field is required")
+			return
+		}
+		p = setProcessor{field: opts.Field, value: opts.Value}
+	case "logfmt":
+		p = logfmtProcessor{field: field, keep: opts.Keep, convert: opts.Convert}
+	case "json":
+		p = jsonProcessor{field: field, keep: opts.Keep}
+	case "regex":
+		if re == nil {
+			err = errors.New("processor regex: pattern is required")
+			return
+		}
+		p = regexProcessor{field: field, regexp: re}
+	case "truncate":
+		if opts.Max <= 0 {
+			err = errors.New("processor truncate: max is required")
+			return
+		}
+		p = truncateProcessor{field: field, max: opts.Max}
+	case "redact":
+		rule := RedactRule{Name: "processor", Preset: opts.Preset, Pattern: opts.Pattern, Action: opts.Action, Replacement: opts.Replacement}
+		if err = rule.compile(); err != nil {
+			err = errors.New("processor " + err.Error())
+			return
+		}
+		p = redactProcessor{field: field, rule: rule}
+	case "fingerprint":
+		if p, err = newFingerprintProcessor(opts); err != nil {
+			return
+		}
+	default:
+		err = errors.New("unknown processor type '" + opts.Type + "'")
+		return
+	}
+	// limit processor to topic and project
+	if len(opts.Topic) > 0 || len(opts.Project) > 0 {
+		if _, err = path.Match(opts.Topic, ""); err != nil {
+			return
+		}
+		if _, err = path.Match(opts.Project, ""); err != nil {
+			return
+		}
+		p = scopedProcessor{topic: opts.Topic, project: opts.Project, processor: p}
+	}
+	return
+}
+
+// runProcessors run records through processors in order
+func runProcessors(ps []Processor, r Record) (rs []Record) {
+	rs = []Record{r}
+	for _, p := range ps {
+		var out []Record
+		for _, r := range rs {
+			out = append(out, p.Process(r)...)
+		}
+		if rs = out; len(rs) == 0 {
+			return
+		}
+	}
+	return
+}
+
+// getField get a record field by name, names other than record fields refer to extra, a leading 'x_' is stripped
+func getField(r Record, name string) (string, bool) {
+	switch name {
+	case "message":
+		return r.Message, len(r.Message) > 0
+	case "env":
+		return r.Env, true
+	case "topic":
+		return r.Topic, true
+	case "project":
+		return r.Project, true
+	case "hostname":
+		return r.Hostname, true
+	case "crid":
+		return r.Crid, len(r.Crid) > 0
+	case "keyword":
+		return r.Keyword, len(r.Keyword) > 0
+	case "tenant":
+		return r.Tenant, len(r.Tenant) > 0
+	}
+	v, ok := r.Extra[strings.TrimPrefix(name, "x_")]
+	if !ok {
+		return "", false
+	}
+	if s, ok := v.(string); ok {
+		return s, true
+	}
+	return fmt.Sprint(v), true
+}
+
+// setField set a record field by name, non-string values are formatted for record fields
+func setField(r *Record, name string, v interface{}) {
+	s, ok := v.(string)
+	if !ok {
+		s = fmt.Sprint(v)
+	}
+	switch name {
+	case "message":
+		r.Message = s
+	case "env":
+		r.Env = s
+	case "topic":
+		r.Topic = s
+	case "project":
+		r.Project = s
+	case "hostname":
+		r.Hostname = s
+	case "crid":
+		r.Crid = s
+	case "keyword":
+		r.Keyword = s
+	case "tenant":
+		r.Tenant = s
+	default:
+		if r.Extra == nil {
+			r.Extra = map[string]interface{}{}
+		}
+		r.Extra[strings.TrimPrefix(name, "x_")] = v
+	}
+}
+
+// deleteField clear a record field or delete an extra field
+func deleteField(r *Record, name string) {
+	switch name {
+	case "message", "env", "topic", "project", "hostname", "crid", "keyword", "tenant":
+		setField(r, name, "")
+	default:
+		delete(r.Extra, strings.TrimPrefix(name, "x_"))
+	}
+}
+
+// copyExtra copy extra map before modifying, records may share the same map
+func copyExtra(r *Record) {
+	m := make(map[string]interface{}, len(r.Extra))
+	for k, v := range r.Extra {
+		m[k] = v
+	}
+	r.Extra = m
+}
+
+type scopedProcessor struct {
+	topic     string
+	project   string
+	processor Processor
+}
+
+func (p scopedProcessor) Process(r Record) []Record {
+	if !matchTopicProject(p.topic, p.project, r.Topic, r.Project) {
+		return []Record{r}
+	}
+	return p.processor.Process(r)
+}
+
+type dropIfProcessor struct {
+	field  string
+	regexp *regexp.Regexp
+}
+
+func (p dropIfProcessor) Process(r Record) []Record {
+	if v, ok := getField(r, p.field); ok && p.regexp.MatchString(v) {
+		return nil
+	}
+	return []Record{r}
+}
+
+type renameProcessor struct {
+	field  string
+	target string
+}
+
+func (p renameProcessor) Process(r Record) []Record {
+	var v interface{}
+	if extra, ok := r.Extra[strings.TrimPrefix(p.field, "x_")]; ok {
+		v = extra
+	} else if s, ok := getField(r, p.field); ok {
+		v = s
+	} else {
+		return []Record{r}
+	}
+	copyExtra(&r)
+	deleteField(&r, p.field)
+	setField(&r, p.target, v)
+	return []Record{r}
+}
+
+type setProcessor struct {
+	field string
+	value string
+}
+
+func (p setProcessor) Process(r Record) []Record {
+	copyExtra(&r)
+	setField(&r, p.field, p.value)
+	return []Record{r}
+}
+
+type logfmtProcessor struct {
+	field   string
+	keep    bool
+	convert bool
+}
+
+func (p logfmtProcessor) Process(r Record) []Record {
+	v, ok := getField(r, p.field)
+	if !ok {
+		return []Record{r}
+	}
+	pairs, _ := parseLogfmt(v, p.convert, nil)
+	if len(pairs) == 0 {
+		return []Record{r}
+	}
+	copyExtra(&r)
+	if !p.keep {
+		deleteField(&r, p.field)
+	}
+	for k, v := range pairs {
+		r.Extra[k] = v
+	}
+	return []Record{r}
+}
+
+type jsonProcessor struct {
+	field string
+	keep  bool
+}
+
+func (p jsonProcessor) Process(r Record) []Record {
+	v, ok := getField(r, p.field)
+	if !ok {
+		return []Record{r}
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(v), &m); err != nil {
+		return []Record{r}
+	}
+	copyExtra(&r)
+	if !p.keep {
+		deleteField(&r, p.field)
+	}
+	for k, v := range m {
+		r.Extra[k] = v
+	}
+	return []Record{r}
+}
+
+type regexProcessor struct {
+	field  string
+	regexp *regexp.Regexp
+}
+
+func (p regexProcessor) Process(r Record) []Record {
+	v, ok := getField(r, p.field)
+	if !ok {
+		return []Record{r}
+	}
+	m := p.regexp.FindStringSubmatch(v)
+	if m == nil {
+		return []Record{r}
+	}
+	copyExtra(&r)
+	for i, name := range p.regexp.SubexpNames() {
+		if len(name) > 0 && len(m[i]) > 0 {
+			setField(&r, name, m[i])
+		}
+	}
+	return []Record{r}
+}
+
+type truncateProcessor struct {
+	field string
+	max   int
+}
+
+func (p truncateProcessor) Process(r Record) []Record {
+	v, ok := getField(r, p.field)
+	if !ok || len(v) <= p.max {
+		return []Record{r}
+	}
+	copyExtra(&r)
+	setField(&r, p.field, truncateUTF8(v, p.max))
+	if p.field == "message" {
+		r.OriginalLength = len(v)
+	}
+	r.Truncated = true
+	return []Record{r}
+}
+
+type redactProcessor struct {
+	field string
+	rule  RedactRule
+}
+
+func (p redactProcessor) Process(r Record) []Record {
+	v, ok := getField(r, p.field)
+	if !ok {
+		return []Record{r}
+	}
+	copyExtra(&r)
+	setField(&r, p.field, p.rule.Redact(v))
+	return []Record{r}
+}
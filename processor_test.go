@@ -0,0 +1,166 @@
+package main
+
+import "testing"
+
+func newTestProcessor(t *testing.T, opts ProcessorOptions) Processor {
+	p, err := NewProcessor(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestNewProcessor(t *testing.T) {
+	for _, opts := range []ProcessorOptions{
+		{Type: "unknown"},
+		{Type: "drop_if"},
+		{Type: "rename", Field: "crid"},
+		{Type: "set"},
+		{Type: "regex", Pattern: "("},
+		{Type: "truncate"},
+		{Type: "redact"},
+	} {
+		if _, err := NewProcessor(opts); err == nil {
+			t.Fatal("should fail", opts)
+		}
+	}
+}
+
+func TestDropIfProcessor(t *testing.T) {
+	p := newTestProcessor(t, ProcessorOptions{Type: "drop_if", Field: "x_level", Pattern: "^debug$"})
+	if rs := p.Process(Record{Extra: map[string]interface{}{"level": "debug"}}); len(rs) != 0 {
+		t.Fatal("should drop")
+	}
+	if rs := p.Process(Record{Extra: map[string]interface{}{"level": "info"}}); len(rs) != 1 {
+		t.Fatal("should keep")
+	}
+	if rs := p.Process(Record{Message: "debug"}); len(rs) != 1 {
+		t.Fatal("should keep missing field")
+	}
+}
+
+func TestRenameProcessor(t *testing.T) {
+	p := newTestProcessor(t, ProcessorOptions{Type: "rename", Field: "x_uid", Target: "x_user_id"})
+	extra := map[string]interface{}{"uid": 10}
+	rs := p.Process(Record{Extra: extra})
+	if rs[0].Extra["user_id"] != 10 || rs[0].Extra["uid"] != nil {
+		t.Fatal("rename extra", rs[0].Extra)
+	}
+	if extra["uid"] != 10 {
+		t.Fatal("original extra modified")
+	}
+	p = newTestProcessor(t, ProcessorOptions{Type: "rename", Field: "x_request_id", Target: "crid"})
+	rs = p.Process(Record{Extra: map[string]interface{}{"request_id": "abc"}})
+	if rs[0].Crid != "abc" || len(rs[0].Extra) != 0 {
+		t.Fatal("rename to crid", rs[0])
+	}
+}
+
+func TestSetProcessor(t *testing.T) {
+	p := newTestProcessor(t, ProcessorOptions{Type: "set", Field: "project", Value: "web"})
+	if rs := p.Process(Record{Project: "api"}); rs[0].Project != "web" {
+		t.Fatal("set project")
+	}
+	p = newTestProcessor(t, ProcessorOptions{Type: "set", Field: "x_dc", Value: "sh"})
+	if rs := p.Process(Record{}); rs[0].Extra["dc"] != "sh" {
+		t.Fatal("set extra")
+	}
+}
+
+func TestLogfmtProcessor(t *testing.T) {
+	p := newTestProcessor(t, ProcessorOptions{Type: "logfmt", Convert: true})
+	rs := p.Process(Record{Message: `user login user_id=12 ratio=0.5 ip=10.0.0.1 msg="hello \"world\""`})
+	if len(rs[0].Message) != 0 {
+		t.Fatal("message should be removed")
+	}
+	if rs[0].Extra["user_id"] != int64(12) || rs[0].Extra["ratio"] != 0.5 || rs[0].Extra["ip"] != "10.0.0.1" || rs[0].Extra["msg"] != `hello "world"` {
+		t.Fatal("pairs", rs[0].Extra)
+	}
+	p = newTestProcessor(t, ProcessorOptions{Type: "logfmt", Keep: true})
+	rs = p.Process(Record{Message: "a=1 b"})
+	if rs[0].Message != "a=1 b" || rs[0].Extra["a"] != "1" || len(rs[0].Extra) != 1 {
+		t.Fatal("keep", rs[0])
+	}
+	if rs = p.Process(Record{Message: "no pairs"}); rs[0].Extra != nil {
+		t.Fatal("no pairs")
+	}
+}
+
+func TestJSONProcessor(t *testing.T) {
+	p := newTestProcessor(t, ProcessorOptions{Type: "json", Field: "x_body"})
+	rs := p.Process(Record{Message: "hello", Extra: map[string]interface{}{"body": `{"code":200}`}})
+	if rs[0].Extra["code"] != float64(200) || rs[0].Extra["body"] != nil || rs[0].Message != "hello" {
+		t.Fatal("json", rs[0])
+	}
+	rs = p.Process(Record{Extra: map[string]interface{}{"body": `not json`}})
+	if rs[0].Extra["body"] != "not json" {
+		t.Fatal("bad json should be kept")
+	}
+}
+
+func TestRegexProcessor(t *testing.T) {
+	p := newTestProcessor(t, ProcessorOptions{Type: "regex", Pattern: `order (?P<x_order>\d+) by (?P<keyword>\w+)`})
+	rs := p.Process(Record{Message: "created order 42 by alice"})
+	if rs[0].Extra["order"] != "42" || rs[0].Keyword != "alice" || rs[0].Message != "created order 42 by alice" {
+		t.Fatal("regex", rs[0])
+	}
+	if rs = p.Process(Record{Message: "nothing"}); rs[0].Extra != nil {
+		t.Fatal("should not match")
+	}
+}
+
+func TestTruncateProcessor(t *testing.T) {
+	p := newTestProcessor(t, ProcessorOptions{Type: "truncate", Max: 4})
+	if rs := p.Process(Record{Message: "hello"}); rs[0].Message != "hell" || !rs[0].Truncated || rs[0].OriginalLength != 5 {
+		t.Fatal("truncate", rs[0].Message)
+	}
+	if rs := p.Process(Record{Message: "a你好"}); rs[0].Message != "a你" {
+		t.Fatal("truncate utf8", rs[0].Message)
+	}
+	if rs := p.Process(Record{Message: "hi"}); rs[0].Message != "hi" {
+		t.Fatal("short")
+	}
+}
+
+func TestRedactProcessor(t *testing.T) {
+	p := newTestProcessor(t, ProcessorOptions{Type: "redact", Pattern: `password=\S+`, Replacement: "password=[redacted]"})
+	if rs := p.Process(Record{Message: "login password=secret ok"}); rs[0].Message != "login password=[redacted] ok" {
+		t.Fatal("redact", rs[0].Message)
+	}
+	p = newTestProcessor(t, ProcessorOptions{Type: "redact", Pattern: `\d{4}`})
+	if rs := p.Process(Record{Message: "pin 1234"}); rs[0].Message != "pin ***" {
+		t.Fatal("default replacement", rs[0].Message)
+	}
+	p = newTestProcessor(t, ProcessorOptions{Type: "redact", Field: "x_contact", Preset: "email", Action: RedactDrop})
+	if rs := p.Process(Record{Extra: map[string]interface{}{"contact": "mail bob@example.com"}}); rs[0].Extra["contact"] != "mail " {
+		t.Fatal("preset", rs[0].Extra)
+	}
+}
+
+func TestScopedProcessor(t *testing.T) {
+	p := newTestProcessor(t, ProcessorOptions{Type: "set", Topic: "access*", Field: "x_kind", Value: "access"})
+	if rs := p.Process(Record{Topic: "access-log"}); rs[0].Extra["kind"] != "access" {
+		t.Fatal("should apply")
+	}
+	if rs := p.Process(Record{Topic: "error"}); rs[0].Extra != nil {
+		t.Fatal("should skip")
+	}
+}
+
+func TestRunProcessors(t *testing.T) {
+	ps := []Processor{
+		newTestProcessor(t, ProcessorOptions{Type: "logfmt"}),
+		newTestProcessor(t, ProcessorOptions{Type: "drop_if", Field: "x_level", Pattern: "debug"}),
+		newTestProcessor(t, ProcessorOptions{Type: "rename", Field: "x_id", Target: "crid"}),
+	}
+	if rs := runProcessors(ps, Record{Message: "level=debug id=1"}); len(rs) != 0 {
+		t.Fatal("should drop")
+	}
+	rs := runProcessors(ps, Record{Message: "level=info id=1"})
+	if len(rs) != 1 || rs[0].Crid != "1" || rs[0].Extra["level"] != "info" {
+		t.Fatal("chain", rs)
+	}
+	if rs := runProcessors(nil, Record{Message: "x"}); len(rs) != 1 {
+		t.Fatal("empty chain")
+	}
+}
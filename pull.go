@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	pullStop  = make(chan struct{})
+	pullGroup = &sync.WaitGroup{}
+)
+
+// pullSession connect to upstream redis and drain list keys until stopped or failed, returns number of pulled events
+func pullSession(opts PullOptions, stop chan struct{}) (n int, err error) {
+	var conn net.Conn
+	if conn, err = net.DialTimeout("tcp", opts.Addr, time.Second*10); err != nil {
+		return
+	}
+	c := newRESPClient(conn)
+	defer c.Close()
+
+	// authenticate and select database
+	var v interface{}
+	if len(opts.Password) > 0 {
+		if v, err = c.Do("AUTH", opts.Password); err != nil {
+			return
+		} else if e, ok := v.(respError); ok {
+			err = e
+			return
+		}
+	}
+	if opts.DB > 0 {
+		if v, err = c.Do("SELECT", strconv.Itoa(opts.DB)); err != nil {
+			return
+		} else if e, ok := v.(respError); ok {
+			err = e
+			return
+		}
+	}
+	log.Info().Str("addr", opts.Addr).Strs("keys", opts.Keys).Msg("pull connection established")
+
+	blpop := append(append([]string{"BLPOP"}, opts.Keys...), strconv.Itoa(opts.Timeout))
+	for {
+		// stop draining if queues are above high-water mark, a throttled session stays connected
+		waitUnthrottled(stop)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		// block for the first event
+		conn.SetDeadline(time.Now().Add(time.Second * time.Duration(opts.Timeout+10)))
+		if v, err = c.Do(blpop...); err != nil {
+			return
+		}
+		if e, ok := v.(respError); ok {
+			err = e
+			return
+		}
+		vs, ok := v.([]interface{})
+		if !ok || len(vs) != 2 {
+			// BLPOP timeout
+			continue
+		}
+		key, _ := vs[0].([]byte)
+		raw, _ := vs[1].([]byte)
+		// events are already removed from upstream, consume or restore all of them
+		raws, perr := popPullBatch(c, string(key), raw, opts.Batch)
+		for i, raw := range raws {
+			if _, err = consumeRawEvent(opts.Addr, string(key), "", raw); err != nil {
+				restorePullEvents(c, opts.Addr, string(key), raws[i:])
+				return
+			}
+			n++
+		}
+		if err = perr; err != nil {
+			return
+		}
+	}
+}
+
+// popPullBatch drain the rest of batch with pipelined LPOP, all replies are read before returning
+func popPullBatch(c *respClient, key string, first []byte, batch int) (raws [][]byte, err error) {
+	raws = [][]byte{first}
+	for i := 1; i < batch; i++ {
+		c.Send("LPOP", key)
+	}
+	if err = c.Flush(); err != nil {
+		return
+	}
+	for i := 1; i < batch; i++ {
+		var v interface{}
+		if v, err = c.Receive(); err != nil {
+			return
+		}
+		if raw, ok := v.([]byte); ok {
+			raws = append(raws, raw)
+		}
+	}
+	return
+}
+
+// restorePullEvents push unconsumed events back to the head of upstream list in original order,
+// save them as dead letters if failed
+func restorePullEvents(c *respClient, addr string, key string, raws [][]byte) {
+	args := []string{"LPUSH", key}
+	for i := len(raws) - 1; i >= 0; i-- {
+		args = append(args, string(raws[i]))
+	}
+	v, err := c.Do(args...)
+	if e, ok := v.(respError); ok && err == nil {
+		err = e
+	}
+	if err == nil {
+		log.Warn().Str("addr", addr).Str("key", key).Int("count", len(raws)).Msg("pulled events pushed back")
+		return
+	}
+	log.Error().Err(err).Str("addr", addr).Str("key", key).Int("count", len(raws)).Msg("failed to push back pulled events")
+	for _, raw := range raws {
+		saveDeadLetter(DeadLetter{Addr: addr, Key: key, Reason: ReasonUnqueued, Raw: string(raw)})
+	}
+}
+
+func pullRoutine(opts PullOptions) {
+	defer pullGroup.Done()
+
+	minBackoff, maxBackoff := time.Millisecond*500, time.Second*30
+	backoff := minBackoff
+	for {
+		n, err := pullSession(opts, pullStop)
+		select {
+		case <-pullStop:
+			log.Info().Str("addr", opts.Addr).Msg("pull connection closed")
+			return
+		default:
+		}
+		// reset backoff if anything pulled
+		if n > 0 {
+			backoff = minBackoff
+		}
+		log.Warn().Err(err).Str("addr", opts.Addr).Dur("backoff", backoff).Msg("pull connection failed, will reconnect")
+		select {
+		case <-pullStop:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func startPull() {
+	for _, opts := range options.Pull {
+		pullGroup.Add(1)
+		go pullRoutine(opts)
+	}
+}
+
+func stopPull() {
+	close(pullStop)
+	pullGroup.Wait()
+}
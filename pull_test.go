@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yankeguo/redcon"
+)
+
+// memoryQueue in-memory diskqueue.DiskQueue for testing
+type memoryQueue struct {
+	mutex sync.Mutex
+	items [][]byte
+	err   error // returned once items reach max
+	max   int
+}
+
+func (q *memoryQueue) Put(buf []byte) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.err != nil && len(q.items) >= q.max {
+		return q.err
+	}
+	q.items = append(q.items, buf)
+	return nil
+}
+
+func (q *memoryQueue) ReadChan() chan []byte { return nil }
+func (q *memoryQueue) Close() error          { return nil }
+func (q *memoryQueue) Delete() error         { return nil }
+func (q *memoryQueue) Empty() error          { return nil }
+
+func (q *memoryQueue) Depth() int64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return int64(len(q.items))
+}
+
+// testRedisList redis stand-in with lists, supports AUTH, BLPOP, LPOP and LPUSH
+type testRedisList struct {
+	mutex sync.Mutex
+	lists map[string][][]byte
+}
+
+func (l *testRedisList) pop(key string) []byte {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.lists[key]) == 0 {
+		return nil
+	}
+	v := l.lists[key][0]
+	l.lists[key] = l.lists[key][1:]
+	return v
+}
+
+func (l *testRedisList) get(key string) [][]byte {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.lists[key]
+}
+
+func newTestRedisList(t *testing.T, lists map[string][][]byte) (l *testRedisList, s *redcon.Server) {
+	l = &testRedisList{lists: lists}
+	s = redcon.NewServer("127.0.0.1:0", func(conn redcon.Conn, cmd redcon.Command) {
+		switch strings.ToLower(string(cmd.Args[0])) {
+		case "auth":
+			if string(cmd.Args[1]) != "secret" {
+				conn.WriteError("ERR invalid password")
+				return
+			}
+			conn.WriteString("OK")
+		case "blpop":
+			for _, key := range cmd.Args[1 : len(cmd.Args)-1] {
+				if v := l.pop(string(key)); v != nil {
+					conn.WriteArray(2)
+					conn.WriteBulk(key)
+					conn.WriteBulk(v)
+					return
+				}
+			}
+			time.Sleep(time.Millisecond * 10)
+			conn.WriteNull()
+		case "lpop":
+			if v := l.pop(string(cmd.Args[1])); v != nil {
+				conn.WriteBulk(v)
+			} else {
+				conn.WriteNull()
+			}
+		case "lpush":
+			key := string(cmd.Args[1])
+			l.mutex.Lock()
+			for _, v := range cmd.Args[2:] {
+				l.lists[key] = append([][]byte{append([]byte{}, v...)}, l.lists[key]...)
+			}
+			n := len(l.lists[key])
+			l.mutex.Unlock()
+			conn.WriteInt(n)
+		default:
+			conn.WriteError("ERR unknown command")
+		}
+	}, nil, nil)
+	setup := make(chan error, 1)
+	go s.ListenServeAndSignal(setup)
+	if err := <-setup; err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestPullSession(t *testing.T) {
+	list := map[string][][]byte{}
+	for i := 0; i < 5; i++ {
+		list["xlog"] = append(list["xlog"], []byte(`{"message":"[2018/07/20 15:03:00.000] hello","source":"/var/log/test/err/project.log"}`))
+	}
+	_, s := newTestRedisList(t, list)
+	defer s.Close()
+
+	q := &memoryQueue{}
+	outputs = map[string]*Output{defaultOutput: {Name: defaultOutput, Queue: q}}
+	defer func() {
+		outputs = map[string]*Output{}
+	}()
+
+	// bad password
+	opts := PullOptions{Addr: s.Addr().String(), Password: "bad", Keys: []string{"audit", "xlog"}, Batch: 3, Timeout: 1}
+	if _, err := pullSession(opts, make(chan struct{})); err == nil {
+		t.Fatal("auth")
+	}
+
+	// drain all events
+	opts.Password = "secret"
+	stop := make(chan struct{})
+	done := make(chan int)
+	go func() {
+		n, _ := pullSession(opts, stop)
+		done <- n
+	}()
+	for i := 0; i < 100 && q.Depth() < 5; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	close(stop)
+	if n := <-done; n != 5 {
+		t.Fatal("pulled", n)
+	}
+	if q.Depth() != 5 {
+		t.Fatal("queued", q.Depth())
+	}
+}
+
+func TestPullSession_QueueFailure(t *testing.T) {
+	list := map[string][][]byte{}
+	for i := 0; i < 6; i++ {
+		list["xlog"] = append(list["xlog"], []byte(fmt.Sprintf(`{"message":"[2018/07/20 15:03:00.000] hello %d","source":"/var/log/test/err/project.log"}`, i)))
+	}
+	l, s := newTestRedisList(t, list)
+	defer s.Close()
+
+	// queue fails after 2 events, the rest of batch is pushed back in order
+	q := &memoryQueue{err: errors.New("disk full"), max: 2}
+	outputs = map[string]*Output{defaultOutput: {Name: defaultOutput, Queue: q}}
+	defer func() {
+		outputs = map[string]*Output{}
+	}()
+	opts := PullOptions{Addr: s.Addr().String(), Keys: []string{"xlog"}, Batch: 5, Timeout: 1}
+	n, err := pullSession(opts, make(chan struct{}))
+	if err == nil || n != 2 || q.Depth() != 2 {
+		t.Fatal("failure", n, err, q.Depth())
+	}
+	rest := l.get("xlog")
+	if len(rest) != 4 {
+		t.Fatal("pushed back", len(rest))
+	}
+	for i, raw := range rest {
+		if !strings.Contains(string(raw), fmt.Sprintf("hello %d", i+2)) {
+			t.Fatal("order", i, string(raw))
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+const (
+	// RedactMask replace the match with replacement
+	RedactMask = "mask"
+	// RedactHash replace the match with a truncated sha256 hash, equal values remain correlatable
+	RedactHash = "hash"
+	// RedactDrop remove the match
+	RedactDrop = "drop"
+
+	redactDenyFields = "deny_fields"
+)
+
+var (
+	redactCounts      = map[string]int64{}
+	redactCountsMutex = &sync.Mutex{}
+)
+
+// built-in redaction patterns
+var redactPresets = map[string]string{
+	"bearer":    `(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`,
+	"email":     `[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`,
+	"cn_mobile": `(?:\+86[- ]?|\b86[- ]?|\b)1[3-9]\d{9}\b`,
+	"card":      `\b(?:\d[ -]?){12,18}\d\b`,
+}
+
+// validators of built-in redaction patterns, matches not valid are kept
+var redactValidators = map[string]func(string) bool{
+	"card": luhnValid,
+}
+
+// luhnValid check the digits of s with the Luhn algorithm, non-digits are skipped
+func luhnValid(s string) bool {
+	var sum, n int
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
+
+func countRedaction(name string, n int) {
+	redactCountsMutex.Lock()
+	redactCounts[name] = redactCounts[name] + int64(n)
+	redactCountsMutex.Unlock()
+}
+
+// redactionCounts copy counters of redaction rules
+func redactionCounts() (counts map[string]int64) {
+	redactCountsMutex.Lock()
+	defer redactCountsMutex.Unlock()
+	counts = map[string]int64{}
+	for k, v := range redactCounts {
+		counts[k] = v
+	}
+	return
+}
+
+// redactReplace replace a matched or denied value by action
+func redactReplace(action, replacement, s string) string {
+	switch action {
+	case RedactHash:
+		sum := sha256.Sum256([]byte(s))
+		return "sha256:" + hex.EncodeToString(sum[:])[:16]
+	case RedactDrop:
+		return ""
+	default:
+		return replacement
+	}
+}
+
+func checkRedactAction(action string) error {
+	switch action {
+	case RedactMask, RedactHash, RedactDrop:
+		return nil
+	}
+	return errors.New("unknown redact action '" + action + "'")
+}
+
+// compile compile the pattern or preset of redact rule and fill defaults
+func (o *RedactRule) compile() (err error) {
+	pattern := o.Pattern
+	if len(o.Preset) > 0 {
+		if len(pattern) > 0 {
+			return errors.New("redact rule: preset and pattern are exclusive")
+		}
+		var ok bool
+		if pattern, ok = redactPresets[o.Preset]; !ok {
+			return errors.New("unknown redact preset '" + o.Preset + "'")
+		}
+		o.validate = redactValidators[o.Preset]
+	}
+	if len(pattern) == 0 {
+		return errors.New("redact rule: preset or pattern is required")
+	}
+	if len(o.Name) == 0 {
+		o.Name = o.Preset
+	}
+	if len(o.Action) == 0 {
+		o.Action = RedactMask
+	}
+	if err = checkRedactAction(o.Action); err != nil {
+		return
+	}
+	if len(o.Replacement) == 0 {
+		o.Replacement = "***"
+	}
+	o.regexp, err = regexp.Compile(pattern)
+	return
+}
+
+// Redact replace all valid matches in s
+func (o RedactRule) Redact(s string) string {
+	var n int
+	s = o.regexp.ReplaceAllStringFunc(s, func(m string) string {
+		if o.validate != nil && !o.validate(m) {
+			return m
+		}
+		n++
+		return redactReplace(o.Action, o.Replacement, m)
+	})
+	if n > 0 {
+		countRedaction(o.Name, n)
+	}
+	return s
+}
+
+// redactString apply all redact rules
+func redactString(s string) string {
+	for _, rule := range options.Redact.Rules {
+		s = rule.Redact(s)
+	}
+	return s
+}
+
+// redactValue redact strings in value, maps and slices are copied
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return redactString(v)
+	case map[string]interface{}:
+		return redactExtra(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = redactValue(e)
+		}
+		return out
+	}
+	return v
+}
+
+// redactExtra redact values and denied fields of extra, nested maps included
+func redactExtra(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if stringSliceContainsIgnoreCase(options.Redact.DenyFields, k) {
+			countRedaction(redactDenyFields, 1)
+			if options.Redact.DenyAction == RedactDrop {
+				continue
+			}
+			out[k] = redactReplace(options.Redact.DenyAction, "***", fmt.Sprint(v))
+			continue
+		}
+		out[k] = redactValue(v)
+	}
+	return out
+}
+
+// redactRecord redact message and extra of record
+func redactRecord(r *Record) {
+	if len(options.Redact.Rules) == 0 && len(options.Redact.DenyFields) == 0 {
+		return
+	}
+	r.Message = redactString(r.Message)
+	if len(r.Extra) > 0 {
+		r.Extra = redactExtra(r.Extra)
+	}
+}
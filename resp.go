@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/yankeguo/redcon"
+)
+
+// respError error reply from redis server
+type respError string
+
+func (e respError) Error() string {
+	return string(e)
+}
+
+// respClient a minimal redis client
+type respClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *redcon.Writer
+}
+
+func newRESPClient(conn net.Conn) *respClient {
+	return &respClient{conn: conn, r: bufio.NewReader(conn), w: redcon.NewWriter(conn)}
+}
+
+// Send buffer a command
+func (c *respClient) Send(args ...string) {
+	c.w.WriteArray(len(args))
+	for _, arg := range args {
+		c.w.WriteBulkString(arg)
+	}
+}
+
+// Flush write all buffered commands
+func (c *respClient) Flush() error {
+	return c.w.Flush()
+}
+
+// Receive read a reply
+func (c *respClient) Receive() (interface{}, error) {
+	return readRESPReply(c.r)
+}
+
+// Do send a command and read the reply
+func (c *respClient) Do(args ...string) (interface{}, error) {
+	c.Send(args...)
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	return c.Receive()
+}
+
+// Close close the underlying connection
+func (c *respClient) Close() error {
+	return c.conn.Close()
+}
+
+// readRESPReply read a reply, simple strings are returned as string, bulk strings as []byte, integers as int64,
+// arrays as []interface{}, null bulk strings and arrays as nil, error replies as respError
+func readRESPReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("resp: bad reply line")
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return respError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		var l int
+		if l, err = strconv.Atoi(line[1:]); err != nil {
+			return nil, err
+		}
+		if l < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, l+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:l], nil
+	case '*':
+		var l int
+		if l, err = strconv.Atoi(line[1:]); err != nil {
+			return nil, err
+		}
+		if l < 0 {
+			return nil, nil
+		}
+		vs := make([]interface{}, 0, l)
+		for i := 0; i < l; i++ {
+			var v interface{}
+			if v, err = readRESPReply(r); err != nil {
+				return nil, err
+			}
+			vs = append(vs, v)
+		}
+		return vs, nil
+	}
+	return nil, errors.New("resp: unknown reply type")
+}
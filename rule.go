@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// RuleDrop drop the record
+	RuleDrop = "drop"
+	// RuleKeep keep the record, skipping ignore and enforce_keyword checks
+	RuleKeep = "keep"
+	// RuleRoute send the record to another index or output, ignore and enforce_keyword checks still apply
+	RuleRoute = "route"
+)
+
+// compile compile patterns of rule options
+func (o *RuleOptions) compile() (err error) {
+	for _, p := range []string{o.Env, o.Project, o.Topic, o.Hostname} {
+		if _, err = path.Match(p, ""); err != nil {
+			return
+		}
+	}
+	if len(o.Message) > 0 {
+		if o.message, err = regexp.Compile(o.Message); err != nil {
+			return
+		}
+	}
+	o.extra = map[string]*regexp.Regexp{}
+	for k, v := range o.Extra {
+		if o.extra[k], err = regexp.Compile(v); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Match check record against all conditions set
+func (o RuleOptions) Match(r Record) bool {
+	for _, c := range [][2]string{{o.Env, r.Env}, {o.Project, r.Project}, {o.Topic, r.Topic}, {o.Hostname, r.Hostname}} {
+		if len(c[0]) == 0 {
+			continue
+		}
+		if ok, _ := path.Match(c[0], c[1]); !ok {
+			return false
+		}
+	}
+	if o.message != nil && !o.message.MatchString(r.Message) {
+		return false
+	}
+	if len(o.Keyword) > 0 && !strings.Contains(r.Keyword, o.Keyword) {
+		return false
+	}
+	for k, re := range o.extra {
+		if v, ok := getField(r, "x_"+strings.TrimPrefix(k, "x_")); !ok || !re.MatchString(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRule find the first rule matching the record
+func matchRule(rules []RuleOptions, r Record) (rule RuleOptions, ok bool) {
+	for _, rule = range rules {
+		if ok = rule.Match(r); ok {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestRuleOptions_Match(t *testing.T) {
+	rule := RuleOptions{
+		Env:      "prod*",
+		Hostname: "web-*",
+		Message:  `GET /health`,
+		Keyword:  "lb",
+		Extra:    map[string]string{"x_status": "^2", "agent": "kube"},
+	}
+	if err := rule.compile(); err != nil {
+		t.Fatal(err)
+	}
+	r := Record{
+		Env:      "production",
+		Hostname: "web-01",
+		Message:  "GET /health 200",
+		Keyword:  "lb,probe",
+		Extra:    map[string]interface{}{"status": 200, "agent": "kube-probe"},
+	}
+	if !rule.Match(r) {
+		t.Fatal("should match")
+	}
+	r.Hostname = "db-01"
+	if rule.Match(r) {
+		t.Fatal("hostname")
+	}
+	r.Hostname = "web-01"
+	r.Extra = map[string]interface{}{"status": 500, "agent": "kube-probe"}
+	if rule.Match(r) {
+		t.Fatal("extra")
+	}
+	r.Extra = map[string]interface{}{"status": 200}
+	if rule.Match(r) {
+		t.Fatal("missing extra")
+	}
+	if !(RuleOptions{}).Match(r) {
+		t.Fatal("empty rule matches all")
+	}
+	bad := RuleOptions{Message: "("}
+	if err := bad.compile(); err == nil {
+		t.Fatal("bad message")
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	rules := []RuleOptions{
+		{Topic: "access", Message: "health", Action: RuleDrop},
+		{Env: "staging", Action: RuleRoute, Output: "cheap"},
+	}
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rule, ok := matchRule(rules, Record{Topic: "access", Env: "staging", Message: "GET /health"}); !ok || rule.Action != RuleDrop {
+		t.Fatal("first rule")
+	}
+	if rule, ok := matchRule(rules, Record{Topic: "access", Env: "staging", Message: "GET /"}); !ok || rule.Output != "cheap" {
+		t.Fatal("second rule")
+	}
+	if _, ok := matchRule(rules, Record{Topic: "access", Env: "prod"}); ok {
+		t.Fatal("no rule")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	sampledOut int64
+)
+
+// sampleWindow records seen and kept in a second
+type sampleWindow struct {
+	second int64
+	seen   int64
+	kept   int64
+	rate   float64
+}
+
+// sampleWindows rate-cap state by key, shared by copies of SamplingOptions
+type sampleWindows struct {
+	mutex   sync.Mutex
+	windows map[string]*sampleWindow
+}
+
+// take keep at most limit records per second for key, rate is the seen to kept ratio of the previous second
+func (w *sampleWindows) take(key string, now time.Time, limit int64) (rate float64, ok bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	sec := now.Unix()
+	win := w.windows[key]
+	if win == nil {
+		win = &sampleWindow{second: sec, rate: 1}
+		w.windows[key] = win
+	}
+	if win.second != sec {
+		if win.second == sec-1 && win.kept > 0 {
+			win.rate = float64(win.seen) / float64(win.kept)
+		} else {
+			win.rate = 1
+		}
+		win.second, win.seen, win.kept = sec, 0, 0
+	}
+	win.seen++
+	if win.kept >= limit {
+		return win.rate, false
+	}
+	win.kept++
+	return win.rate, true
+}
+
+// check check and prepare sampling options
+func (o *SamplingOptions) check() (err error) {
+	for _, p := range []string{o.Env, o.Topic, o.Project} {
+		if _, err = path.Match(p, ""); err != nil {
+			return
+		}
+	}
+	if (o.Percent > 0) == (o.Rate > 0) {
+		return errors.New("sampling requires exactly one of percent and rate")
+	}
+	if o.Percent < 0 || o.Percent > 100 {
+		return errors.New("sampling percent should be in (0, 100]")
+	}
+	o.windows = &sampleWindows{windows: map[string]*sampleWindow{}}
+	return
+}
+
+// Match check env, topic and project of record
+func (o SamplingOptions) Match(r Record) bool {
+	if len(o.Env) > 0 {
+		if ok, _ := path.Match(o.Env, r.Env); !ok {
+			return false
+		}
+	}
+	return matchTopicProject(o.Topic, o.Project, r.Topic, r.Project)
+}
+
+// Keep decide whether to keep the record, rate is the number of records a kept record represents
+func (o SamplingOptions) Keep(r Record, now time.Time) (rate float64, ok bool) {
+	if o.Rate > 0 {
+		return o.windows.take(r.Env+"/"+r.Topic+"/"+r.Project, now, o.Rate)
+	}
+	var n uint32
+	if o.HashCrid && len(r.Crid) > 0 {
+		h := fnv.New32a()
+		h.Write([]byte(r.Crid))
+		n = h.Sum32() % 10000
+	} else {
+		n = uint32(rand.Intn(10000))
+	}
+	return 100 / o.Percent, float64(n) < o.Percent*100
+}
+
+// sampleRecord apply the first matched sampling options, kept records carry extra 'sample_rate'
+func sampleRecord(r *Record, now time.Time) bool {
+	for _, o := range options.Sampling {
+		if !o.Match(*r) {
+			continue
+		}
+		rate, ok := o.Keep(*r, now)
+		if !ok {
+			atomic.AddInt64(&sampledOut, 1)
+			return false
+		}
+		copyExtra(r)
+		r.Extra["sample_rate"] = rate
+		return true
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSamplingOptions_Check(t *testing.T) {
+	for _, o := range []SamplingOptions{
+		{},
+		{Percent: 10, Rate: 10},
+		{Percent: 120},
+		{Topic: "[", Percent: 10},
+	} {
+		if err := o.check(); err == nil {
+			t.Fatal("should fail", o)
+		}
+	}
+}
+
+func TestSamplingOptions_Percent(t *testing.T) {
+	o := SamplingOptions{Percent: 10}
+	if err := o.check(); err != nil {
+		t.Fatal(err)
+	}
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		rate, ok := o.Keep(Record{}, time.Now())
+		if rate != 10 {
+			t.Fatal("rate", rate)
+		}
+		if ok {
+			kept++
+		}
+	}
+	if kept < 800 || kept > 1200 {
+		t.Fatal("kept", kept)
+	}
+}
+
+func TestSamplingOptions_HashCrid(t *testing.T) {
+	o := SamplingOptions{Percent: 50, HashCrid: true}
+	if err := o.check(); err != nil {
+		t.Fatal(err)
+	}
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		crid := fmt.Sprintf("crid-%d", i)
+		_, ok1 := o.Keep(Record{Crid: crid, Message: "a"}, time.Now())
+		_, ok2 := o.Keep(Record{Crid: crid, Message: "b"}, time.Now())
+		if ok1 != ok2 {
+			t.Fatal("same crid should be kept or dropped together")
+		}
+		if ok1 {
+			kept++
+		}
+	}
+	if kept < 400 || kept > 600 {
+		t.Fatal("kept", kept)
+	}
+}
+
+func TestSamplingOptions_Rate(t *testing.T) {
+	o := SamplingOptions{Rate: 2}
+	if err := o.check(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	r := Record{Topic: "access"}
+	kept := 0
+	for i := 0; i < 8; i++ {
+		if rate, ok := o.Keep(r, now); ok {
+			if rate != 1 {
+				t.Fatal("first second rate", rate)
+			}
+			kept++
+		}
+	}
+	if kept != 2 {
+		t.Fatal("kept", kept)
+	}
+	if _, ok := o.Keep(Record{Topic: "other"}, now); !ok {
+		t.Fatal("other key")
+	}
+	if rate, ok := o.Keep(r, now.Add(time.Second)); !ok || rate != 4 {
+		t.Fatal("next second", rate, ok)
+	}
+	if rate, ok := o.Keep(r, now.Add(time.Second*5)); !ok || rate != 1 {
+		t.Fatal("idle", rate, ok)
+	}
+}
+
+func TestSampleRecord(t *testing.T) {
+	options = Options{Sampling: []SamplingOptions{
+		{Topic: "access", Project: "web", Percent: 100},
+		{Topic: "access", Rate: 1},
+	}}
+	defer func() {
+		options = Options{}
+	}()
+	for i := range options.Sampling {
+		if err := options.Sampling[i].check(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := Record{Topic: "access", Project: "web"}
+	if !sampleRecord(&r, time.Now()) || r.Extra["sample_rate"] != float64(1) {
+		t.Fatal("percent", r.Extra)
+	}
+	r = Record{Topic: "error"}
+	if !sampleRecord(&r, time.Now()) || r.Extra != nil {
+		t.Fatal("no sampling")
+	}
+	now := time.Now()
+	r = Record{Topic: "access", Project: "api"}
+	if !sampleRecord(&r, now) {
+		t.Fatal("first")
+	}
+	before := sampledOut
+	r = Record{Topic: "access", Project: "api"}
+	if sampleRecord(&r, now) || sampledOut != before+1 {
+		t.Fatal("should be sampled out")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"regexp"
+)
+
+// compile compile the pattern of source options
+func (s *SourceOptions) compile() (err error) {
+	s.regexp, err = regexp.Compile(s.Pattern)
+	return
+}
+
+// Decode match the source path, named captures and defaults are assigned to record fields or extra,
+// record is only modified if env, topic and project are all resolved
+func (s SourceOptions) Decode(raw string, r *Record) bool {
+	if s.regexp == nil {
+		return false
+	}
+	m := s.regexp.FindStringSubmatch(raw)
+	if m == nil {
+		return false
+	}
+	// collect defaults and named captures
+	values := map[string]string{}
+	for k, v := range s.Defaults {
+		values[k] = v
+	}
+	for i, name := range s.regexp.SubexpNames() {
+		if len(name) > 0 && len(m[i]) > 0 {
+			values[name] = m[i]
+		}
+	}
+	if len(values["env"]) == 0 || len(values["topic"]) == 0 || len(values["project"]) == 0 {
+		return false
+	}
+	// assign fields
+	for k, v := range values {
+		switch k {
+		case "env":
+			r.Env = v
+		case "topic":
+			r.Topic = v
+		case "project":
+			r.Project = v
+		case "hostname":
+			r.Hostname = v
+		default:
+			if r.Extra == nil {
+				r.Extra = map[string]interface{}{}
+			}
+			r.Extra[k] = v
+		}
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestSourceOptions_Decode(t *testing.T) {
+	s := SourceOptions{
+		Pattern:  `^/var/log/containers/(?P<project>[^_]+)_(?P<env>[^_]+)_(?P<container>[^-]+)-`,
+		Defaults: map[string]string{"topic": "stdout"},
+	}
+	if err := s.compile(); err != nil {
+		t.Fatal(err)
+	}
+	var r Record
+	if !s.Decode("/var/log/containers/api.customer_prod_main-0123abcd.log", &r) {
+		t.Fatal("failed")
+	}
+	if r.Env != "prod" || r.Topic != "stdout" || r.Project != "api.customer" {
+		t.Fatal("fields", r.Env, r.Topic, r.Project)
+	}
+	if r.Extra["container"] != "main" {
+		t.Fatal("extra", r.Extra)
+	}
+	if s.Decode("/srv/app/logs/app.log", &r) {
+		t.Fatal("not matched")
+	}
+	// missing project
+	s = SourceOptions{Pattern: `^/srv/(?P<project>[^/]*)/logs/`, Defaults: map[string]string{"env": "prod", "topic": "app"}}
+	s.compile()
+	if s.Decode("/srv//logs/app.log", &r) {
+		t.Fatal("missing project")
+	}
+}
+
+func TestDecodeBeatSource(t *testing.T) {
+	defer func() {
+		options = Options{}
+	}()
+	options.Sources = []SourceOptions{{Pattern: `^/srv/(?P<project>[^/]+)/logs/(?P<topic>[^.]+)\.log$`, Defaults: map[string]string{"env": "prod"}}}
+	options.Sources[0].compile()
+	var r Record
+	if !decodeBeatSource("/srv/app/logs/err.log", &r) || r.Env != "prod" || r.Topic != "err" || r.Project != "app" {
+		t.Fatal("pattern", r)
+	}
+	// fallback
+	if !decodeBeatSource("/var/log/prod/err/api-customer.20180719.log", &r) || r.Env != "prod" || r.Topic != "err" || r.Project != "api-customer" {
+		t.Fatal("fallback", r)
+	}
+	if decodeBeatSource("app.log", &r) {
+		t.Fatal("bad source")
+	}
+}
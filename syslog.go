@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	syslogDefaultMaxFrameSize = 16 * 1024 * 1024
+)
+
+var (
+	syslogUDP net.PacketConn
+	syslogTCP net.Listener
+
+	syslogDropped int64
+
+	syslogFacilities = []string{
+		"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+		"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+		"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+	}
+	syslogSeverities = []string{
+		"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+	}
+)
+
+// SyslogMessage a decoded RFC 3164 or RFC 5424 message
+type SyslogMessage struct {
+	Facility       int
+	Severity       int
+	Timestamp      time.Time
+	Zoned          bool // timestamp carries zone information
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData string
+	Message        string
+}
+
+// ToRecord convert syslog message to record, env, topic and project are resolved with rules
+func (m SyslogMessage) ToRecord(opts SyslogOptions, offset int) (r Record) {
+	r.Timestamp = m.Timestamp
+	r.Hostname = m.Hostname
+	r.Message = m.Message
+	r.Extra = map[string]interface{}{
+		"facility": syslogFacilities[m.Facility],
+		"severity": syslogSeverities[m.Severity],
+	}
+	if len(m.AppName) > 0 {
+		r.Extra["app_name"] = m.AppName
+	}
+	if len(m.ProcID) > 0 {
+		r.Extra["proc_id"] = m.ProcID
+	}
+	if len(m.MsgID) > 0 {
+		r.Extra["msg_id"] = m.MsgID
+	}
+	if len(m.StructuredData) > 0 {
+		r.Extra["structured_data"] = m.StructuredData
+	}
+	// defaults
+	r.Env, r.Topic, r.Project = opts.Env, opts.Topic, opts.Project
+	// first matched rule
+	for _, rule := range opts.Rules {
+		if !rule.Match(m) {
+			continue
+		}
+		if len(rule.Env) > 0 {
+			r.Env = rule.Env
+		}
+		if len(rule.Topic) > 0 {
+			r.Topic = rule.Topic
+		}
+		if len(rule.Project) > 0 {
+			r.Project = rule.Project
+		}
+		break
+	}
+	// project falls back to app-name
+	if len(r.Project) == 0 {
+		r.Project = m.AppName
+	}
+	if len(r.Project) == 0 {
+		r.Project = "unknown"
+	}
+	// elasticsearch index names must be lowercase
+	r.Env, r.Topic, r.Project = strings.ToLower(r.Env), strings.ToLower(r.Topic), strings.ToLower(r.Project)
+	// localize timestamp without zone information
+	if !m.Zoned {
+		r.Timestamp = localizeTimestamp(r.Timestamp, r.Env, r.Project, r.Hostname, offset)
+	}
+	return
+}
+
+// Match check if rule matches syslog message
+func (r SyslogRule) Match(m SyslogMessage) bool {
+	if len(r.Hostname) > 0 {
+		if ok, _ := path.Match(r.Hostname, m.Hostname); !ok {
+			return false
+		}
+	}
+	if len(r.AppName) > 0 {
+		if ok, _ := path.Match(r.AppName, m.AppName); !ok {
+			return false
+		}
+	}
+	if len(r.Facility) > 0 && !strings.EqualFold(r.Facility, syslogFacilities[m.Facility]) {
+		return false
+	}
+	return true
+}
+
+// nextSyslogField cut the next space separated field, '-' is treated as nil value
+func nextSyslogField(buf []byte) (field string, rest []byte) {
+	if i := bytes.IndexByte(buf, ' '); i < 0 {
+		field, rest = string(buf), nil
+	} else {
+		field, rest = string(buf[:i]), buf[i+1:]
+	}
+	if field == "-" {
+		field = ""
+	}
+	return
+}
+
+// cutSyslogStructuredData cut the STRUCTURED-DATA part of RFC 5424 message
+func cutSyslogStructuredData(buf []byte) (sd string, rest []byte, ok bool) {
+	if len(buf) > 0 && buf[0] == '-' {
+		rest = bytes.TrimPrefix(buf[1:], []byte{' '})
+		ok = true
+		return
+	}
+	var i int
+	for i < len(buf) && buf[i] == '[' {
+		// find the closing bracket, skip escaped characters and quoted strings
+		var quoted bool
+		for i++; i < len(buf); i++ {
+			if buf[i] == '\\' {
+				i++
+			} else if buf[i] == '"' {
+				quoted = !quoted
+			} else if buf[i] == ']' && !quoted {
+				break
+			}
+		}
+		if i >= len(buf) {
+			return
+		}
+		i++
+	}
+	if i == 0 {
+		return
+	}
+	sd, rest, ok = string(buf[:i]), bytes.TrimPrefix(buf[i:], []byte{' '}), true
+	return
+}
+
+// parseSyslog parse a RFC 3164 or RFC 5424 message
+func parseSyslog(buf []byte, now time.Time) (m SyslogMessage, ok bool) {
+	buf = bytes.TrimRight(buf, "\r\n\x00")
+	// PRI
+	if len(buf) < 3 || buf[0] != '<' {
+		return
+	}
+	var i int
+	if i = bytes.IndexByte(buf, '>'); i < 2 || i > 4 {
+		return
+	}
+	pri, err := strconv.Atoi(string(buf[1:i]))
+	if err != nil || pri < 0 || pri > 191 {
+		return
+	}
+	m.Facility, m.Severity = pri/8, pri%8
+	buf = buf[i+1:]
+	// RFC 5424, VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP STRUCTURED-DATA [SP MSG]
+	if len(buf) > 2 && buf[0] == '1' && buf[1] == ' ' {
+		var ts string
+		ts, buf = nextSyslogField(buf[2:])
+		if len(ts) > 0 {
+			if m.Timestamp, err = time.Parse(time.RFC3339Nano, ts); err != nil {
+				return
+			}
+			m.Zoned = true
+		} else {
+			m.Timestamp, m.Zoned = now, true
+		}
+		m.Hostname, buf = nextSyslogField(buf)
+		m.AppName, buf = nextSyslogField(buf)
+		m.ProcID, buf = nextSyslogField(buf)
+		m.MsgID, buf = nextSyslogField(buf)
+		if m.StructuredData, buf, ok = cutSyslogStructuredData(buf); !ok {
+			return
+		}
+		m.Message = strings.TrimPrefix(string(buf), "\xef\xbb\xbf")
+		return
+	}
+	// RFC 3164, TIMESTAMP SP HOSTNAME SP TAG MSG
+	if len(buf) >= 16 && buf[15] == ' ' {
+		if m.Timestamp, err = time.Parse(time.Stamp, string(buf[:15])); err == nil {
+			// year is missing, assume the last year if timestamp is in the future
+			m.Timestamp = m.Timestamp.AddDate(now.Year(), 0, 0)
+			if m.Timestamp.Sub(now) > time.Hour*24 {
+				m.Timestamp = m.Timestamp.AddDate(-1, 0, 0)
+			}
+			buf = buf[16:]
+		}
+	}
+	// RFC 3339 timestamp without version is also commonly used
+	if m.Timestamp.IsZero() {
+		var ts string
+		ts, buf = nextSyslogField(buf)
+		if m.Timestamp, err = time.Parse(time.RFC3339Nano, ts); err != nil {
+			return
+		}
+		m.Zoned = true
+	}
+	m.Hostname, buf = nextSyslogField(buf)
+	// TAG, terminated by '[', ':' or space
+	if i = bytes.IndexAny(buf, "[: "); i > 0 && i <= 48 {
+		m.AppName, buf = string(buf[:i]), buf[i:]
+		if buf[0] == '[' {
+			if j := bytes.IndexByte(buf, ']'); j > 0 {
+				m.ProcID, buf = string(buf[1:j]), buf[j+1:]
+			}
+		}
+		buf = bytes.TrimPrefix(buf, []byte{':'})
+	}
+	m.Message = string(bytes.TrimSpace(buf))
+	ok = true
+	return
+}
+
+// syslogMaxFrameSize frames larger than hard drop threshold are rejected, smaller ones are truncated by limits
+func syslogMaxFrameSize() int {
+	if options.Limits.HardDrop > 0 {
+		return options.Limits.HardDrop
+	}
+	return syslogDefaultMaxFrameSize
+}
+
+// readSyslogFrame read a octet-counted or newline delimited frame from a TCP stream
+func readSyslogFrame(r *bufio.Reader) (buf []byte, err error) {
+	max := syslogMaxFrameSize()
+	var b []byte
+	if b, err = r.Peek(1); err != nil {
+		return
+	}
+	// newline delimited
+	if b[0] < '0' || b[0] > '9' {
+		var line []byte
+		for {
+			if line, err = r.ReadSlice('\n'); err == bufio.ErrBufferFull {
+				if buf = append(buf, line...); len(buf) > max {
+					err = errors.New("syslog frame too large")
+					return
+				}
+				continue
+			}
+			buf = append(buf, line...)
+			if err == io.EOF && len(buf) > 0 {
+				err = nil
+			}
+			return
+		}
+	}
+	// octet counting, at most 10 digits followed by a space
+	var l int
+	for i := 0; ; i++ {
+		var c byte
+		if c, err = r.ReadByte(); err != nil {
+			return
+		}
+		if c == ' ' && i > 0 {
+			break
+		}
+		if c < '0' || c > '9' || i >= 10 {
+			err = errors.New("syslog frame: invalid octet count")
+			return
+		}
+		if l = l*10 + int(c-'0'); l > max {
+			err = errors.New("syslog frame too large")
+			return
+		}
+	}
+	buf = make([]byte, l)
+	_, err = io.ReadFull(r, buf)
+	return
+}
+
+// consumeSyslogMessage parse and queue a syslog message, returns false if message is saved as a dead letter,
+// err is set if message is neither queued nor saved
+func consumeSyslogMessage(addr string, key string, raw []byte) (ok bool, err error) {
+	// dead letter message > hard drop threshold
+	if options.Limits.HardDrop > 0 && len(raw) > options.Limits.HardDrop {
+		err = saveDeadLetter(DeadLetter{Addr: addr, Key: key, Syslog: true, Reason: ReasonOversize, Raw: string(raw)})
+		return
+	}
+	p, found := resolvePipeline(key)
+	if !found {
+		err = saveDeadLetter(DeadLetter{Addr: addr, Key: key, Syslog: true, Reason: ReasonNoPipeline, Raw: string(raw)})
+		return
+	}
+	m, parsed := parseSyslog(raw, time.Now())
+	if !parsed {
+		err = saveDeadLetter(DeadLetter{Addr: addr, Key: key, Syslog: true, Reason: ReasonBadSyslog, Raw: string(raw)})
+		return
+	}
+	if len(m.Hostname) == 0 {
+		m.Hostname = extractIP(addr)
+	}
+	record := m.ToRecord(options.Syslog, *p.TimeOffset)
+	truncateRecord(&record, len(raw))
+	if err = enqueueRecord(p, record); err != nil {
+		return
+	}
+	ok = true
+	return
+}
+
+func syslogUDPRoutine() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := syslogUDP.ReadFrom(buf)
+		if err != nil {
+			log.Info().Err(err).Msg("syslog udp server closed")
+			return
+		}
+		// UDP can not be paused, drop messages if queues are above high-water mark
+		if isThrottled() {
+			atomic.AddInt64(&syslogDropped, 1)
+			continue
+		}
+		if _, err = consumeSyslogMessage(addr.String(), options.Syslog.Key, buf[:n]); err != nil {
+			log.Error().Err(err).Str("addr", addr.String()).Msg("failed to persist syslog message")
+		}
+	}
+}
+
+func syslogTCPRoutine() {
+	for {
+		conn, err := syslogTCP.Accept()
+		if err != nil {
+			log.Info().Err(err).Msg("syslog tcp server closed")
+			return
+		}
+		go syslogConnRoutine(conn)
+	}
+}
+
+func syslogConnRoutine(conn net.Conn) {
+	defer conn.Close()
+	addr := conn.RemoteAddr().String()
+	log.Debug().Str("addr", addr).Msg("syslog connection established")
+	r := bufio.NewReader(conn)
+	for {
+		// stop reading if queues are above high-water mark
+		waitUnthrottled(nil)
+		buf, err := readSyslogFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Info().Err(err).Str("addr", addr).Msg("syslog connection closed")
+			}
+			return
+		}
+		if len(bytes.TrimSpace(buf)) > 0 {
+			if _, err = consumeSyslogMessage(addr, options.Syslog.Key, buf); err != nil {
+				log.Error().Err(err).Str("addr", addr).Msg("failed to persist syslog message, closing")
+				return
+			}
+		}
+	}
+}
+
+func startSyslog() (err error) {
+	if len(options.Syslog.UDP) > 0 {
+		if syslogUDP, err = net.ListenPacket("udp", options.Syslog.UDP); err != nil {
+			return
+		}
+		go syslogUDPRoutine()
+		log.Info().Str("bind", options.Syslog.UDP).Msg("syslog udp server started")
+	}
+	if len(options.Syslog.TCP) > 0 {
+		if syslogTCP, err = net.Listen("tcp", options.Syslog.TCP); err != nil {
+			return
+		}
+		go syslogTCPRoutine()
+		log.Info().Str("bind", options.Syslog.TCP).Msg("syslog tcp server started")
+	}
+	return
+}
+
+func stopSyslog() {
+	if syslogUDP != nil {
+		syslogUDP.Close()
+	}
+	if syslogTCP != nil {
+		syslogTCP.Close()
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog_RFC3164(t *testing.T) {
+	now := time.Date(2018, time.October, 12, 0, 0, 0, 0, time.UTC)
+	m, ok := parseSyslog([]byte("<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick on /dev/pts/8\n"), now)
+	if !ok {
+		t.Fatal("failed")
+	}
+	if m.Facility != 4 || m.Severity != 2 {
+		t.Fatal("pri", m.Facility, m.Severity)
+	}
+	if !m.Timestamp.Equal(time.Date(2018, time.October, 11, 22, 14, 15, 0, time.UTC)) || m.Zoned {
+		t.Fatal("timestamp", m.Timestamp)
+	}
+	if m.Hostname != "mymachine" || m.AppName != "su" || m.ProcID != "123" {
+		t.Fatal("header", m.Hostname, m.AppName, m.ProcID)
+	}
+	if m.Message != "'su root' failed for lonvick on /dev/pts/8" {
+		t.Fatal("message", m.Message)
+	}
+	// timestamp in the future belongs to last year
+	m, _ = parseSyslog([]byte("<13>Dec 31 23:59:59 host app: hello"), time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC))
+	if m.Timestamp.Year() != 2018 {
+		t.Fatal("year", m.Timestamp)
+	}
+	if _, ok = parseSyslog([]byte("hello"), now); ok {
+		t.Fatal("bad")
+	}
+}
+
+func TestParseSyslog_RFC5424(t *testing.T) {
+	m, ok := parseSyslog([]byte(`<165>1 2003-10-11T22:14:15.003+08:00 mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="App]lication"] An application event`), time.Now())
+	if !ok {
+		t.Fatal("failed")
+	}
+	if m.Facility != 20 || m.Severity != 5 {
+		t.Fatal("pri", m.Facility, m.Severity)
+	}
+	if !m.Timestamp.Equal(time.Date(2003, time.October, 11, 14, 14, 15, 3000000, time.UTC)) || !m.Zoned {
+		t.Fatal("timestamp", m.Timestamp)
+	}
+	if m.Hostname != "mymachine.example.com" || m.AppName != "evntslog" || m.ProcID != "" || m.MsgID != "ID47" {
+		t.Fatal("header", m.Hostname, m.AppName, m.ProcID, m.MsgID)
+	}
+	if m.StructuredData != `[exampleSDID@32473 iut="3" eventSource="App]lication"]` {
+		t.Fatal("structured data", m.StructuredData)
+	}
+	if m.Message != "An application event" {
+		t.Fatal("message", m.Message)
+	}
+	if m, ok = parseSyslog([]byte(`<165>1 2003-10-11T22:14:15.003Z host app - - -`), time.Now()); !ok || m.Message != "" {
+		t.Fatal("nil structured data")
+	}
+}
+
+func TestSyslogMessage_ToRecord(t *testing.T) {
+	m := SyslogMessage{Severity: 3, Facility: 16, Hostname: "sw-01", AppName: "Kernel", Timestamp: time.Date(2018, time.October, 11, 22, 14, 15, 0, time.UTC)}
+	opts := SyslogOptions{Env: "default", Topic: "syslog", Rules: []SyslogRule{
+		{Hostname: "web-*", Topic: "web"},
+		{Hostname: "sw-*", Env: "prod", Topic: "network"},
+	}}
+	r := m.ToRecord(opts, -8)
+	if r.Env != "prod" || r.Topic != "network" || r.Project != "kernel" {
+		t.Fatal("rules", r.Env, r.Topic, r.Project)
+	}
+	if r.Timestamp.Hour() != 14 {
+		t.Fatal("offset", r.Timestamp)
+	}
+	if r.Extra["severity"] != "err" || r.Extra["facility"] != "local0" {
+		t.Fatal("extra", r.Extra)
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("11 <13>hello\nw<13>world\n<13>last"))
+	for _, expected := range []string{"<13>hello\nw", "<13>world\n", "<13>last"} {
+		buf, err := readSyslogFrame(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != expected {
+			t.Fatal("frame", string(buf))
+		}
+	}
+	if _, err := readSyslogFrame(r); err == nil {
+		t.Fatal("eof")
+	}
+	// frame limit follows hard drop threshold
+	options = Options{Limits: LimitOptions{HardDrop: 10}}
+	defer func() {
+		options = Options{}
+	}()
+	r = bufio.NewReader(strings.NewReader("10 <13>hello111 <13>hello!!"))
+	if buf, err := readSyslogFrame(r); err != nil || string(buf) != "<13>hello1" {
+		t.Fatal("frame", string(buf), err)
+	}
+	if _, err := readSyslogFrame(r); err == nil {
+		t.Fatal("should be too large")
+	}
+	// octet count prefix is bounded
+	r = bufio.NewReader(strings.NewReader(strings.Repeat("1", 1000) + " <13>hello"))
+	if _, err := readSyslogFrame(r); err == nil || r.Buffered() < 900 {
+		t.Fatal("should reject long prefix", err)
+	}
+}
+
+func TestSyslogUDPRoutine_Throttled(t *testing.T) {
+	var err error
+	if syslogUDP, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	q := &memoryQueue{}
+	outputs = map[string]*Output{defaultOutput: {Name: defaultOutput, Queue: q}}
+	options = Options{Syslog: SyslogOptions{Key: "syslog", Env: "default", Topic: "syslog"}}
+	atomic.StoreInt32(&throttled, 1)
+	defer func() {
+		atomic.StoreInt32(&throttled, 0)
+		options = Options{}
+		outputs = map[string]*Output{}
+	}()
+	go syslogUDPRoutine()
+	defer syslogUDP.Close()
+	conn, err := net.Dial("udp", syslogUDP.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	dropped := atomic.LoadInt64(&syslogDropped)
+	conn.Write([]byte("<34>Oct 11 22:14:15 mymachine su: hello"))
+	for i := 0; i < 100 && atomic.LoadInt64(&syslogDropped) == dropped; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if atomic.LoadInt64(&syslogDropped) != dropped+1 || q.Depth() != 0 {
+		t.Fatal("should drop while throttled")
+	}
+}
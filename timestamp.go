@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// built-in timestamp layouts, any other layout is treated as a Go time layout
+const (
+	LayoutXlog    = "xlog"     // [2018/07/20 15:03:00.000], '-' is also allowed as date separator, fraction can be 3, 6 or 9 digits
+	LayoutISO8601 = "iso8601"  // 2018-07-20T15:03:00.123456+08:00, optionally bracketed
+	LayoutNginx   = "nginx"    // [10/Oct/2018:13:55:36 +0800]
+	LayoutEpoch   = "epoch"    // 1532098980 or 1532098980.123
+	LayoutEpochMS = "epoch_ms" // 1532098980123
+)
+
+var (
+	defaultTimestampLayouts = []string{LayoutXlog}
+)
+
+// resolveTimestampLayouts find layouts of the first matched rule, or global layouts
+func resolveTimestampLayouts(topic, project string) []string {
+	for _, rule := range options.Timestamps {
+		if matchTopicProject(rule.Topic, rule.Project, topic, project) {
+			return rule.Layouts
+		}
+	}
+	if len(options.TimestampLayouts) > 0 {
+		return options.TimestampLayouts
+	}
+	return defaultTimestampLayouts
+}
+
+// cutTimestampCandidate cut the bracketed content, or the leading n space separated fields
+func cutTimestampCandidate(buf []byte, n int) (candidate string, rest []byte, bracketed bool, ok bool) {
+	if len(buf) == 0 {
+		return
+	}
+	if buf[0] == '[' {
+		i := bytes.IndexByte(buf, ']')
+		if i < 0 {
+			return
+		}
+		return string(buf[1:i]), buf[i+1:], true, true
+	}
+	i := 0
+	for ; n > 0; n-- {
+		j := bytes.IndexAny(buf[i:], " \t")
+		if j < 0 {
+			if n > 1 {
+				return
+			}
+			i = len(buf)
+			break
+		}
+		if i += j; n > 1 {
+			i++
+		}
+	}
+	return string(buf[:i]), buf[i:], false, true
+}
+
+// isZonedLayout check if a Go time layout carries zone information
+func isZonedLayout(layout string) bool {
+	for _, token := range []string{"Z07", "-07", "MST"} {
+		if strings.Contains(layout, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTimestampLayout parse timestamp prefix with a single layout, zoned is true if timestamp carries zone information
+func parseTimestampLayout(buf []byte, layout string) (t time.Time, rest []byte, zoned bool, ok bool) {
+	var candidate string
+	var bracketed bool
+	var err error
+	switch layout {
+	case LayoutXlog:
+		if candidate, rest, bracketed, ok = cutTimestampCandidate(buf, 2); !ok || !bracketed {
+			ok = false
+			return
+		}
+		// normalize separators, fraction is required
+		candidate = strings.Replace(strings.Replace(candidate, "/", "-", 2), "\t", " ", 1)
+		if i := strings.LastIndexByte(candidate, '.'); i < 0 || !(len(candidate)-i-1 == 3 || len(candidate)-i-1 == 6 || len(candidate)-i-1 == 9) {
+			ok = false
+			return
+		}
+		t, err = time.Parse("2006-01-02 15:04:05.999999999", candidate)
+	case LayoutISO8601:
+		if candidate, rest, _, ok = cutTimestampCandidate(buf, 1); !ok {
+			return
+		}
+		t, err = time.Parse(time.RFC3339Nano, candidate)
+		zoned = true
+	case LayoutNginx:
+		if candidate, rest, bracketed, ok = cutTimestampCandidate(buf, 2); !ok || !bracketed {
+			ok = false
+			return
+		}
+		t, err = time.Parse("02/Jan/2006:15:04:05 -0700", candidate)
+		zoned = true
+	case LayoutEpoch, LayoutEpochMS:
+		if candidate, rest, _, ok = cutTimestampCandidate(buf, 1); !ok {
+			return
+		}
+		if layout == LayoutEpochMS {
+			t, err = parseEpoch(candidate, time.Millisecond)
+		} else {
+			t, err = parseEpoch(candidate, time.Second)
+		}
+		zoned = true
+	default:
+		if candidate, rest, _, ok = cutTimestampCandidate(buf, strings.Count(layout, " ")+1); !ok {
+			return
+		}
+		t, err = time.Parse(layout, candidate)
+		zoned = isZonedLayout(layout)
+	}
+	if err != nil {
+		ok = false
+		return
+	}
+	t = t.UTC()
+	return
+}
+
+// parseEpoch parse integer and fraction parts of an epoch in unit separately, keeping nanosecond precision
+func parseEpoch(s string, unit time.Duration) (t time.Time, err error) {
+	integer, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		integer, fraction = s[:i], s[i+1:]
+	}
+	var n, f int64
+	if n, err = strconv.ParseInt(integer, 10, 64); err != nil {
+		return
+	}
+	if n < 0 || n > math.MaxInt64/int64(unit) {
+		err = errors.New("epoch out of range")
+		return
+	}
+	// digits beyond nanosecond are dropped
+	scale := int64(unit)
+	for i := 0; i < len(fraction); i++ {
+		if fraction[i] < '0' || fraction[i] > '9' {
+			err = errors.New("invalid epoch fraction")
+			return
+		}
+		if scale >= 10 {
+			scale /= 10
+			f += int64(fraction[i]-'0') * scale
+		}
+	}
+	t = time.Unix(0, n*int64(unit)+f)
+	return
+}
+
+// parseTimestamp parse timestamp prefix with layouts in order
+func parseTimestamp(buf []byte, layouts []string) (t time.Time, rest []byte, zoned bool, ok bool) {
+	buf = bytes.TrimSpace(buf)
+	for _, layout := range layouts {
+		if t, rest, zoned, ok = parseTimestampLayout(buf, layout); ok {
+			return
+		}
+	}
+	return
+}
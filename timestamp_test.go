@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	all := []string{LayoutXlog, LayoutNginx, LayoutISO8601, LayoutEpochMS, "2006-01-02 15:04:05.000000"}
+	cases := []struct {
+		raw   string
+		t     time.Time
+		zoned bool
+		rest  string
+	}{
+		{"[2018/07/20 15:03:00.120] hello", time.Date(2018, time.July, 20, 15, 3, 0, 120000000, time.UTC), false, " hello"},
+		{"[2018-07-20\t15:03:00.000001] hello", time.Date(2018, time.July, 20, 15, 3, 0, 1000, time.UTC), false, " hello"},
+		{"[10/Oct/2018:13:55:36 +0800] GET /", time.Date(2018, time.October, 10, 5, 55, 36, 0, time.UTC), true, " GET /"},
+		{"2018-07-20T15:03:00.123456789+08:00 hello", time.Date(2018, time.July, 20, 7, 3, 0, 123456789, time.UTC), true, " hello"},
+		{"1532098980123 hello", time.Date(2018, time.July, 20, 15, 3, 0, 123000000, time.UTC), true, " hello"},
+		{"2018-07-20 15:03:00.123456 hello", time.Date(2018, time.July, 20, 15, 3, 0, 123456000, time.UTC), false, " hello"},
+	}
+	for _, c := range cases {
+		ts, rest, zoned, ok := parseTimestamp([]byte(c.raw), all)
+		if !ok {
+			t.Fatal("failed", c.raw)
+		}
+		if !ts.Equal(c.t) || zoned != c.zoned || string(rest) != c.rest {
+			t.Fatal("mismatch", c.raw, ts, zoned, string(rest))
+		}
+	}
+	// legacy layout requires bracket and 3, 6 or 9 fractional digits
+	for _, raw := range []string{"2018/07/20 15:03:00.000 hello", "[2018/07/20 15:03:00] hello", "[2018/07/20 15:03:00.0000] hello"} {
+		if _, _, _, ok := parseTimestamp([]byte(raw), defaultTimestampLayouts); ok {
+			t.Fatal("legacy", raw)
+		}
+	}
+	if ts, _, _, ok := parseTimestamp([]byte("1532098980.5"), []string{LayoutEpoch}); !ok || !ts.Equal(time.Date(2018, time.July, 20, 15, 3, 0, 500000000, time.UTC)) {
+		t.Fatal("epoch", ts)
+	}
+	// nanosecond precision is kept
+	if ts, _, _, ok := parseTimestamp([]byte("1532098980.123456789"), []string{LayoutEpoch}); !ok || ts.Nanosecond() != 123456789 {
+		t.Fatal("epoch nanosecond", ts)
+	}
+	if ts, _, _, ok := parseTimestamp([]byte("1532098980123.456789"), []string{LayoutEpochMS}); !ok || ts.Unix() != 1532098980 || ts.Nanosecond() != 123456789 {
+		t.Fatal("epoch_ms fraction", ts)
+	}
+	if _, _, _, ok := parseTimestamp([]byte("1532098980.12a"), []string{LayoutEpoch}); ok {
+		t.Fatal("bad fraction")
+	}
+}
+
+func TestResolveTimestampLayouts(t *testing.T) {
+	defer func() {
+		options = Options{}
+	}()
+	if layouts := resolveTimestampLayouts("access", "web"); len(layouts) != 1 || layouts[0] != LayoutXlog {
+		t.Fatal("default", layouts)
+	}
+	options.TimestampLayouts = []string{LayoutISO8601, LayoutXlog}
+	options.Timestamps = []TimestampOptions{{Topic: "access", Project: "web-*", Layouts: []string{LayoutNginx}}}
+	if layouts := resolveTimestampLayouts("access", "web-api"); len(layouts) != 1 || layouts[0] != LayoutNginx {
+		t.Fatal("rule", layouts)
+	}
+	if layouts := resolveTimestampLayouts("access", "api"); len(layouts) != 2 {
+		t.Fatal("global", layouts)
+	}
+}
+
+func TestEvent_ToRecord_ZonedTimestamp(t *testing.T) {
+	defer func() {
+		options = Options{}
+	}()
+	options.Timestamps = []TimestampOptions{{Topic: "access", Layouts: []string{LayoutNginx}}}
+	be := Event{Message: "[10/Oct/2018:13:55:36 +0800] GET /", Source: "/var/log/prod/access/web.log"}
+	r, _, ok := be.ToRecord(-8)
+	if !ok {
+		t.Fatal("failed")
+	}
+	if !r.Timestamp.Equal(time.Date(2018, time.October, 10, 5, 55, 36, 0, time.UTC)) {
+		t.Fatal("offset should be skipped", r.Timestamp)
+	}
+	if r.Message != "GET /" {
+		t.Fatal("message", r.Message)
+	}
+}
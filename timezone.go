@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path"
+	"time"
+)
+
+// loadLocations load all configured time zones
+func (opt *Options) loadLocations() (err error) {
+	opt.locations = map[string]*time.Location{}
+	names := []string{opt.TimeZone}
+	for _, name := range opt.TimeZones.Envs {
+		names = append(names, name)
+	}
+	for _, name := range opt.TimeZones.Projects {
+		names = append(names, name)
+	}
+	for _, h := range opt.TimeZones.Hosts {
+		if _, err = path.Match(h.Hostname, ""); err != nil {
+			return
+		}
+		names = append(names, h.TimeZone)
+	}
+	for _, name := range names {
+		if len(name) == 0 {
+			continue
+		}
+		if opt.locations[name], err = time.LoadLocation(name); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// resolveLocation resolve time zone by precedence hostname pattern > project > env > global, nil if not configured
+func resolveLocation(env, project, hostname string) *time.Location {
+	for _, h := range options.TimeZones.Hosts {
+		if ok, _ := path.Match(h.Hostname, hostname); ok {
+			return options.locations[h.TimeZone]
+		}
+	}
+	if name, ok := options.TimeZones.Projects[project]; ok {
+		return options.locations[name]
+	}
+	if name, ok := options.TimeZones.Envs[env]; ok {
+		return options.locations[name]
+	}
+	if len(options.TimeZone) > 0 {
+		return options.locations[options.TimeZone]
+	}
+	return nil
+}
+
+// localizeTimestamp reinterpret a timestamp without zone information in the resolved time zone,
+// falls back to offset in hours if no time zone configured
+func localizeTimestamp(t time.Time, env, project, hostname string, offset int) time.Time {
+	if loc := resolveLocation(env, project, hostname); loc != nil {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc).UTC()
+	}
+	return t.Add(time.Hour * time.Duration(offset))
+}
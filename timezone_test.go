@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocalizeTimestamp(t *testing.T) {
+	defer func() {
+		options = Options{}
+	}()
+	ts := time.Date(2018, time.July, 20, 15, 3, 0, 0, time.UTC)
+	if !localizeTimestamp(ts, "prod", "api", "web-01", -8).Equal(time.Date(2018, time.July, 20, 7, 3, 0, 0, time.UTC)) {
+		t.Fatal("offset fallback")
+	}
+	options = Options{
+		TimeZone: "Asia/Shanghai",
+		TimeZones: TimeZonesOptions{
+			Envs:     map[string]string{"us": "America/New_York"},
+			Projects: map[string]string{"india": "Asia/Kolkata"},
+			Hosts:    []HostTimeZoneOptions{{Hostname: "eu-*", TimeZone: "Europe/Berlin"}},
+		},
+	}
+	if err := options.loadLocations(); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		env, project, hostname string
+		expected               time.Time
+	}{
+		{"prod", "api", "web-01", time.Date(2018, time.July, 20, 7, 3, 0, 0, time.UTC)},
+		{"us", "api", "web-01", time.Date(2018, time.July, 20, 19, 3, 0, 0, time.UTC)},      // DST, UTC-4
+		{"us", "india", "web-01", time.Date(2018, time.July, 20, 9, 33, 0, 0, time.UTC)},    // half-hour zone
+		{"us", "india", "eu-web-01", time.Date(2018, time.July, 20, 13, 3, 0, 0, time.UTC)}, // DST, UTC+2
+	}
+	for _, c := range cases {
+		if r := localizeTimestamp(ts, c.env, c.project, c.hostname, -8); !r.Equal(c.expected) {
+			t.Fatal("mismatch", c, r)
+		}
+	}
+	options.TimeZone = "Mars/Olympus"
+	if err := options.loadLocations(); err == nil {
+		t.Fatal("bad time zone")
+	}
+}
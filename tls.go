@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+)
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+)
+
+// redisServer plain or TLS redis protocol server
+type redisServer interface {
+	ListenServeAndSignal(signal chan error) error
+	Close() error
+}
+
+// buildTLSConfig build server TLS config from options
+func buildTLSConfig(opts TLSOptions) (cfg *tls.Config, err error) {
+	cfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	// certificate
+	var cert tls.Certificate
+	if cert, err = tls.LoadX509KeyPair(opts.Cert, opts.Key); err != nil {
+		return
+	}
+	cfg.Certificates = []tls.Certificate{cert}
+	// minimum version
+	if len(opts.MinVersion) > 0 {
+		var ok bool
+		if cfg.MinVersion, ok = tlsVersions[opts.MinVersion]; !ok {
+			err = errors.New("unknown tls min_version '" + opts.MinVersion + "'")
+			return
+		}
+	}
+	// client certificate verification
+	if len(opts.ClientCA) > 0 {
+		var buf []byte
+		if buf, err = ioutil.ReadFile(opts.ClientCA); err != nil {
+			return
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(buf) {
+			err = errors.New("no certificate found in tls client_ca")
+			return
+		}
+		if opts.RequireClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	} else if opts.RequireClientCert {
+		err = errors.New("tls client_ca is required to verify client certificates")
+		return
+	}
+	return
+}
+
+// peerCommonName CN of verified client certificate, empty if not a TLS connection or no client certificate
+func peerCommonName(conn net.Conn) string {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	state := tc.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
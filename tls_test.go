@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createTestCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{cn},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tpl.IsCA, tpl.BasicConstraintsValid, tpl.KeyUsage = true, true, x509.KeyUsageCertSign
+		parent, parentKey = tpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestBuildTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xlogd-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey, caPEM, _ := createTestCert(t, "xlogd-ca", nil, nil)
+	_, _, serverPEM, serverKeyPEM := createTestCert(t, "xlogd.local", ca, caKey)
+	_, _, clientPEM, clientKeyPEM := createTestCert(t, "team-a", ca, caKey)
+	opts := TLSOptions{
+		Cert:              filepath.Join(dir, "server.pem"),
+		Key:               filepath.Join(dir, "server-key.pem"),
+		ClientCA:          filepath.Join(dir, "ca.pem"),
+		RequireClientCert: true,
+		MinVersion:        "1.3",
+	}
+	ioutil.WriteFile(opts.Cert, serverPEM, 0644)
+	ioutil.WriteFile(opts.Key, serverKeyPEM, 0644)
+	ioutil.WriteFile(opts.ClientCA, caPEM, 0644)
+
+	cfg, err := buildTLSConfig(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 || cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatal("config", cfg.MinVersion, cfg.ClientAuth)
+	}
+
+	// handshake and extract CN
+	clientCert, _ := tls.X509KeyPair(clientPEM, clientKeyPEM)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	c1, c2 := net.Pipe()
+	go func() {
+		tc := tls.Client(c1, &tls.Config{RootCAs: pool, ServerName: "xlogd.local", Certificates: []tls.Certificate{clientCert}})
+		tc.Handshake()
+		tc.Write([]byte("x"))
+	}()
+	sc := tls.Server(c2, cfg)
+	if err = sc.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if cn := peerCommonName(sc); cn != "team-a" {
+		t.Fatal("cn", cn)
+	}
+	if cn := peerCommonName(c2); cn != "" {
+		t.Fatal("plain cn", cn)
+	}
+
+	// bad options
+	opts.MinVersion = "2.0"
+	if _, err = buildTLSConfig(opts); err == nil {
+		t.Fatal("min version")
+	}
+	opts.MinVersion, opts.ClientCA = "", ""
+	if _, err = buildTLSConfig(opts); err == nil {
+		t.Fatal("client ca")
+	}
+}
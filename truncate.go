@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// truncateRecord cut extra fields over max_extra and message over max_message, the message and then the largest extra
+// fields are also cut to fit max_raw approximately if the raw event is larger, truncated records are marked with the
+// original message length
+func truncateRecord(r *Record, rawSize int) {
+	l := options.Limits
+	// extra fields, non-string values are replaced by truncated JSON
+	if l.MaxExtra > 0 {
+		var copied bool
+		for k, v := range r.Extra {
+			s := extraString(v)
+			if len(s) <= l.MaxExtra {
+				continue
+			}
+			if !copied {
+				copyExtra(r)
+				copied = true
+			}
+			r.Extra[k] = truncateUTF8(s, l.MaxExtra)
+			rawSize -= len(s) - l.MaxExtra
+			r.Truncated = true
+		}
+	}
+	// message
+	max := len(r.Message)
+	if l.MaxMessage > 0 && max > l.MaxMessage {
+		max = l.MaxMessage
+	}
+	if l.MaxRaw > 0 && rawSize > l.MaxRaw && len(r.Message)-(rawSize-l.MaxRaw) < max {
+		max = len(r.Message) - (rawSize - l.MaxRaw)
+	}
+	if max < 0 {
+		max = 0
+	}
+	if max < len(r.Message) {
+		rawSize -= len(r.Message) - max
+		r.OriginalLength = len(r.Message)
+		r.Message = truncateUTF8(r.Message, max)
+		r.Truncated = true
+	}
+	// largest extra fields, for structured events with little or no message
+	if l.MaxRaw > 0 && rawSize > l.MaxRaw && len(r.Extra) > 0 {
+		copyExtra(r)
+		for excess := rawSize - l.MaxRaw; excess > 0; {
+			var key, val string
+			for k, v := range r.Extra {
+				if s := extraString(v); len(s) > len(val) {
+					key, val = k, s
+				}
+			}
+			if len(val) == 0 {
+				break
+			}
+			n := len(val) - excess
+			if n < 0 {
+				n = 0
+			}
+			s := truncateUTF8(val, n)
+			r.Extra[key] = s
+			excess -= len(val) - len(s)
+			r.Truncated = true
+		}
+	}
+}
+
+// extraString extra value as string, non-string values are marshalled as JSON
+func extraString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	buf, _ := json.Marshal(v)
+	return string(buf)
+}
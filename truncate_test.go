@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTruncateRecord(t *testing.T) {
+	options = Options{Limits: LimitOptions{MaxRaw: 100, MaxMessage: 50, MaxExtra: 10}}
+	defer func() {
+		options = Options{}
+	}()
+	// within limits
+	r := Record{Message: "hello", Extra: map[string]interface{}{"a": "short"}}
+	if truncateRecord(&r, 60); r.Truncated || r.Message != "hello" {
+		t.Fatal("within limits", r)
+	}
+	// message over max_message
+	r = Record{Message: strings.Repeat("x", 80)}
+	if truncateRecord(&r, 90); !r.Truncated || len(r.Message) != 50 || r.OriginalLength != 80 {
+		t.Fatal("max_message", len(r.Message), r.OriginalLength)
+	}
+	// message cut to fit max_raw
+	r = Record{Message: strings.Repeat("x", 40)}
+	if truncateRecord(&r, 130); !r.Truncated || len(r.Message) != 10 || r.OriginalLength != 40 {
+		t.Fatal("max_raw", len(r.Message), r.OriginalLength)
+	}
+	// extra over max_extra
+	extra := map[string]interface{}{"s": strings.Repeat("y", 20), "m": map[string]interface{}{"k": "vvvvvvvvvv"}, "n": 1}
+	r = Record{Message: "hello", Extra: extra}
+	truncateRecord(&r, 60)
+	if !r.Truncated || r.Extra["s"] != strings.Repeat("y", 10) || r.Extra["m"] != `{"k":"vvvv` || r.Extra["n"] != 1 || r.OriginalLength != 0 {
+		t.Fatal("max_extra", r.Extra)
+	}
+	if len(extra["s"].(string)) != 20 {
+		t.Fatal("original extra modified")
+	}
+	m := r.Map()
+	if m["truncated"] != true || m["original_length"] != nil {
+		t.Fatal("map", m)
+	}
+}
+
+func TestConsumeRawEvent_Oversize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xlogd-oversize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if deadLetters, err = NewJournal(dir, "dead"); err != nil {
+		t.Fatal(err)
+	}
+	q := &memoryQueue{}
+	outputs = map[string]*Output{defaultOutput: {Name: defaultOutput, Queue: q}}
+	options = Options{Limits: LimitOptions{MaxRaw: 200, MaxMessage: 200, HardDrop: 1000}}
+	defer func() {
+		options = Options{}
+		outputs = map[string]*Output{}
+		deadLetters.Close()
+		deadLetters = nil
+	}()
+	message := "[2018/07/20 15:03:00.000] " + strings.Repeat("z", 500)
+	raw := `{"source":"/tmp/test2/test3/test1.log","beat":{"hostname":"test"},"message":"` + message + `"}`
+	if ok, err := consumeRawEvent("127.0.0.1:1000", "xlog", "", []byte(raw)); !ok || err != nil || q.Depth() != 1 {
+		t.Fatal("should be truncated and queued")
+	}
+	o, err := DecodeOperation(q.items[0])
+	if err != nil || len(o.Body) > 400 || !strings.Contains(string(o.Body), `"original_length":500`) {
+		t.Fatal("truncated", string(o.Body), err)
+	}
+	raw = `{"message":"` + strings.Repeat("z", 1000) + `"}`
+	if ok, err := consumeRawEvent("127.0.0.1:1000", "xlog", "", []byte(raw)); ok || err != nil || q.Depth() != 1 {
+		t.Fatal("should be dropped")
+	}
+	if _, reasons := deadLetterCounts(); reasons[ReasonOversize] == 0 {
+		t.Fatal("should be a dead letter")
+	}
+}
+
+func TestConsumeRawEvent_OversizeJSON(t *testing.T) {
+	q := &memoryQueue{}
+	outputs = map[string]*Output{defaultOutput: {Name: defaultOutput, Queue: q}}
+	options = Options{Limits: LimitOptions{MaxRaw: 300, MaxMessage: 300, HardDrop: 2000}}
+	defer func() {
+		options = Options{}
+		outputs = map[string]*Output{}
+	}()
+	// structured event has no message, extra fields are cut to fit max_raw
+	message := `[2018/07/20 15:03:00.000] {"topic":"audit","body":"` + strings.Repeat("b", 800) + `","small":"keep"}`
+	raw, _ := json.Marshal(map[string]interface{}{"source": "/var/log/test/_json_/project.log", "beat": map[string]string{"hostname": "test"}, "message": message})
+	if ok, err := consumeRawEvent("127.0.0.1:1000", "xlog", "", raw); !ok || err != nil || q.Depth() != 1 {
+		t.Fatal("should be truncated and queued", err)
+	}
+	o, err := DecodeOperation(q.items[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(o.Body) > 400 || !strings.Contains(string(o.Body), `"truncated":true`) || !strings.Contains(string(o.Body), `"keep"`) {
+		t.Fatal("truncated", len(o.Body), string(o.Body))
+	}
+}
+
+func TestReinjectDeadLetters_Oversize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xlogd-oversize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if deadLetters, err = NewJournal(dir, "dead"); err != nil {
+		t.Fatal(err)
+	}
+	outputs = map[string]*Output{defaultOutput: {Name: defaultOutput, Queue: &memoryQueue{}}}
+	options = Options{Limits: LimitOptions{MaxRaw: 1000000, MaxMessage: 1000000, HardDrop: 16 * 1024 * 1024}}
+	defer func() {
+		options = Options{}
+		outputs = map[string]*Output{}
+		deadLetters.Close()
+		deadLetters = nil
+	}()
+	// escaped dead letter line exceeds hard drop threshold
+	raw := `{"message":"` + strings.Repeat(`\"`, 8*1024*1024+1) + `"}`
+	if ok, err := consumeRawEvent("127.0.0.1:1000", "xlog", "", []byte(raw)); ok || err != nil {
+		t.Fatal("should be dead letter", err)
+	}
+	reinjected, failed, err := reinjectDeadLetters()
+	if err != nil || reinjected != 0 || failed != 1 {
+		t.Fatal("reinject", reinjected, failed, err)
+	}
+}
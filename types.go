@@ -1,9 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,43 +25,158 @@ const (
 	eventTopicJSON = "_json_"
 )
 
+var (
+	defaultDocumentIDFields = []string{"hostname", "source", "offset", "timestamp", "crid", "message"}
+)
+
+// reasons of dead letters
+const (
+	ReasonOversize     = "oversize"      // raw event too large
+	ReasonBadJSON      = "bad_json"      // raw event is not a valid JSON
+	ReasonBadSource    = "bad_source"    // source path has less than 3 components
+	ReasonBadTimestamp = "bad_timestamp" // message is not prefixed with a valid timestamp
+	ReasonBadExtra     = "bad_extra"     // message of '_json_' topic is not a valid JSON
+	ReasonMissingTopic = "missing_topic" // message of '_json_' topic has no 'topic' field
+	ReasonBadSyslog    = "bad_syslog"    // syslog message is neither RFC 3164 nor RFC 5424
+	ReasonNoPipeline   = "no_pipeline"   // no pipeline configured for the key
+	ReasonUnqueued     = "unqueued"      // pulled event neither queued nor pushed back upstream
+)
+
 // Stats daemon stats record
 type Stats struct {
-	Timestamp     time.Time
-	Hostname      string `json:"hostname"`
-	RecordsQueued int64  `json:"records_queued"`
-	RecordsTotal  int64  `json:"records_total"`
-	Records1M     int64  `json:"records_1m"`
+	Timestamp      time.Time
+	Hostname       string           `json:"hostname"`
+	RecordsQueued  int64            `json:"records_queued"`
+	RecordsTotal   int64            `json:"records_total"`
+	Records1M      int64            `json:"records_1m"`
+	RecordsFailed  int64            `json:"records_failed"`
+	RecordsDead    int64            `json:"records_dead"`
+	DeadLetters    map[string]int64 `json:"dead_letters"`
+	Throttled      bool             `json:"throttled"`
+	ThrottleEvents int64            `json:"throttle_events"`
+	SampledOut     int64            `json:"sampled_out"`
+	Redactions     map[string]int64 `json:"redactions"`
+	UnknownHosts   int64            `json:"unknown_hosts"`
+	RecordsDup     int64            `json:"records_dup"`
+	SyslogDropped  int64            `json:"syslog_dropped"`
 }
 
 func (r Stats) Index() string {
 	return fmt.Sprintf("x-xlogd-%04d-%02d-%02d", r.Timestamp.Year(), r.Timestamp.Month(), r.Timestamp.Day())
 }
 
-// Event a single event in redis LIST sent by filebeat
+// LooseString string decoded from JSON, values of other types are ignored
+type LooseString string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (s *LooseString) UnmarshalJSON(buf []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return err
+	}
+	if str, ok := v.(string); ok {
+		*s = LooseString(str)
+	}
+	return nil
+}
+
+// EventHost host field of event, either a string or an object with name
+type EventHost struct {
+	Name string `json:"name"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (h *EventHost) UnmarshalJSON(buf []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case string:
+		h.Name = v
+	case map[string]interface{}:
+		h.Name, _ = v["name"].(string)
+	}
+	return nil
+}
+
+// Event a single event in redis LIST sent by filebeat, both legacy layout and ECS layout of filebeat 7+ are supported
 type Event struct {
 	Beat struct {
 		Hostname string `json:"hostname"`
-	} `json:"beat"`                 // contains hostname
-	Message string `json:"message"` // contains timestamp, crid
-	Source  string `json:"source"`  // contains env, topic, project
+	} `json:"beat"` // contains hostname, legacy layout
+	Host  EventHost `json:"host"` // contains hostname, ECS layout
+	Agent struct {
+		Hostname string `json:"hostname"`
+	} `json:"agent"` // contains hostname, ECS layout
+	Log struct {
+		File struct {
+			Path LooseString `json:"path"`
+		} `json:"file"`
+		Offset *int64 `json:"offset"`
+	} `json:"log"` // contains env, topic, project, offset, ECS layout
+	Message string                 `json:"message"` // contains timestamp, crid
+	Source  LooseString            `json:"source"`  // contains env, topic, project, legacy layout
+	Offset  *int64                 `json:"offset"`  // offset of line in source file, legacy layout
+	Fields  map[string]interface{} `json:"fields"`  // user fields
+	Tags    []string               `json:"tags"`    // user tags
+	Cloud   map[string]interface{} `json:"cloud"`   // cloud metadata
 }
 
-// ToRecord implements RecordConvertible
-func (b Event) ToRecord(offset int) (r Record, ok bool) {
+// ToRecord implements RecordConvertible, reason is set if failed
+func (b Event) ToRecord(offset int) (r Record, reason string, ok bool) {
 	// assign hostname
 	r.Hostname = b.Beat.Hostname
+	if len(r.Hostname) == 0 {
+		r.Hostname = b.Host.Name
+	}
+	if len(r.Hostname) == 0 {
+		r.Hostname = b.Agent.Hostname
+	}
 	// decode source field
-	if ok = decodeBeatSource(b.Source, &r); !ok {
+	source := string(b.Source)
+	if len(source) == 0 {
+		source = string(b.Log.File.Path)
+	}
+	if ok = decodeBeatSource(source, &r); !ok {
+		reason = ReasonBadSource
 		return
 	}
+	r.Source = source
+	if r.Offset = b.Offset; r.Offset == nil {
+		r.Offset = b.Log.Offset
+	}
 	// decode message field
 	var noOffset bool
-	if noOffset, ok = decodeBeatMessage(b.Message, strings.Contains(r.Topic, eventTopicJSON), &r); !ok {
+	var kv *LogfmtOptions
+	if opts, found := resolveLogfmt(r.Topic, r.Project); found {
+		kv = &opts
+	}
+	noOffset, reason, ok = decodeBeatMessage(b.Message, resolveTimestampLayouts(r.Topic, r.Project), strings.Contains(r.Topic, eventTopicJSON), kv, &r)
+	// parse access log, the whole message is parsed if timestamp prefix is missing
+	if a, found := resolveAccessLog(r.Topic, r.Project); found && (ok || reason == ReasonBadTimestamp) {
+		if !ok {
+			r.Message = strings.TrimSpace(b.Message)
+		}
+		if t, parsed := a.Decode(r.Message, &r); parsed && a.UseTime && !t.IsZero() {
+			r.Timestamp, noOffset, ok, reason = t, true, true, ""
+		}
+	}
+	if !ok {
 		return
 	}
 	if !noOffset {
-		r.Timestamp = r.Timestamp.Add(time.Hour * time.Duration(offset))
+		r.Timestamp = localizeTimestamp(r.Timestamp, r.Env, r.Project, r.Hostname, offset)
+	}
+	// carry user fields, tags and cloud metadata into extra
+	if len(b.Fields) > 0 {
+		assignExtraIfAbsent(&r, "fields", b.Fields)
+	}
+	if len(b.Tags) > 0 {
+		assignExtraIfAbsent(&r, "tags", b.Tags)
+	}
+	if len(b.Cloud) > 0 {
+		assignExtraIfAbsent(&r, "cloud", b.Cloud)
 	}
 	return
 }
@@ -67,7 +191,13 @@ type Record struct {
 	Crid      string                 `json:"crid"`              // correlation id
 	Message   string                 `json:"message,omitempty"` // the actual log message body
 	Keyword   string                 `json:"keyword"`           // comma separated keywords
+	Tenant    string                 `json:"tenant,omitempty"`  // authenticated sender
 	Extra     map[string]interface{} `json:"extra,omitempty"`   // extra structured data
+
+	Truncated      bool   `json:"truncated,omitempty"`       // message or extra cut to limits
+	OriginalLength int    `json:"original_length,omitempty"` // length of message before truncated
+	Source         string `json:"-"`                         // source file path, only used for document id
+	Offset         *int64 `json:"-"`                         // offset of line in source file, only used for document id
 }
 
 func (r Record) Map() (out map[string]interface{}) {
@@ -87,6 +217,15 @@ func (r Record) Map() (out map[string]interface{}) {
 	if len(r.Message) > 0 {
 		out["message"] = r.Message
 	}
+	if len(r.Tenant) > 0 {
+		out["tenant"] = r.Tenant
+	}
+	if r.Truncated {
+		out["truncated"] = true
+		if r.OriginalLength > 0 {
+			out["original_length"] = r.OriginalLength
+		}
+	}
 	return
 }
 
@@ -102,12 +241,74 @@ func (r Record) ToOperation() (o Operation) {
 	return
 }
 
-// Operation marshalled record
+// DocumentID stable document id from fields, 'source', 'offset' and 'timestamp' are supported besides record and extra fields,
+// defaults to hostname, source, offset, timestamp, crid and message
+func (r Record) DocumentID(fields []string) string {
+	if len(fields) == 0 {
+		fields = defaultDocumentIDFields
+	}
+	h := sha256.New()
+	for _, f := range fields {
+		switch f {
+		case "source":
+			io.WriteString(h, r.Source)
+		case "offset":
+			if r.Offset != nil {
+				io.WriteString(h, strconv.FormatInt(*r.Offset, 10))
+			}
+		case "timestamp":
+			io.WriteString(h, r.Timestamp.UTC().Format(time.RFC3339Nano))
+		default:
+			v, _ := getField(r, f)
+			io.WriteString(h, v)
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Operation marshalled record, operations with id are written with op-type 'create'
 type Operation struct {
 	Index string `json:"index"`
+	ID    string `json:"id"`
 	Body  []byte `json:"body"`
 }
 
+// Encode encode operation for disk queue
+func (o Operation) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(o); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeOperation decode operation from disk queue
+func DecodeOperation(buf []byte) (o Operation, err error) {
+	err = gob.NewDecoder(bytes.NewReader(buf)).Decode(&o)
+	return
+}
+
+// DeadLetter raw event failed to parse or convert
+type DeadLetter struct {
+	Timestamp time.Time `json:"timestamp"` // the time when event received
+	Addr      string    `json:"addr"`      // address of sender
+	Key       string    `json:"key"`       // redis list key or configured key of input
+	Tenant    string    `json:"tenant"`    // authenticated sender
+	Syslog    bool      `json:"syslog"`    // raw is a syslog message instead of a filebeat event
+	Reason    string    `json:"reason"`    // reason of failure
+	Raw       string    `json:"raw"`       // the raw event
+}
+
+// FailedOperation operation permanently rejected by elasticsearch
+type FailedOperation struct {
+	Timestamp time.Time `json:"timestamp"` // the time when operation rejected
+	Index     string    `json:"index"`     // target index
+	Status    int       `json:"status"`    // status of bulk response item
+	Error     string    `json:"error"`     // error type and reason
+	Body      string    `json:"body"`      // the document
+}
+
 // Options options for xlogd
 type Options struct {
 	// Dev
@@ -118,21 +319,529 @@ type Options struct {
 	Bind string `yaml:"bind"`
 	// DataDir
 	DataDir string `yaml:"data_dir"`
+	// FailureDir
+	// directory to store operations permanently rejected by elasticsearch, defaults to '{data_dir}/failed'
+	FailureDir string `yaml:"failure_dir"`
+	// DeadLetterDir
+	// directory to store events failed to parse or convert, defaults to '{data_dir}/dead'
+	// dead letters can be re-injected with command 'REINJECT' once the parser is fixed
+	DeadLetterDir string `yaml:"dead_letter_dir"`
 	// Multi
 	Multi bool `yaml:"multi"`
+	// Backpressure
+	// refuse pushes when queues are above high-water marks
+	Backpressure BackpressureOptions `yaml:"backpressure"`
+	// TLS
+	// TLS options for redis protocol listener, TLS is enabled if cert is set
+	TLS TLSOptions `yaml:"tls"`
+	// Auth
+	// credentials for redis protocol AUTH and HTTP basic authentication, authentication is required if configured
+	Auth AuthOptions `yaml:"auth"`
+	// HTTP
+	// HTTP ingestion endpoint options
+	HTTP HTTPOptions `yaml:"http"`
+	// Syslog
+	// syslog input options
+	Syslog SyslogOptions `yaml:"syslog"`
+	// Lumberjack
+	// lumberjack v2 input options, for filebeat 'logstash' output
+	Lumberjack LumberjackOptions `yaml:"lumberjack"`
+	// Pull
+	// upstream redis instances to drain with BLPOP/LPOP
+	Pull []PullOptions `yaml:"pull"`
 	// Elasticsearch
-	// Elasticsearch options
+	// Elasticsearch options, this is the output 'default'
 	Elasticsearch ElasticsearchOptions `yaml:"elasticsearch"`
+	// Outputs
+	// additional elasticsearch outputs, referenced by name in pipelines, each output has its own queue
+	Outputs map[string]ElasticsearchOptions `yaml:"outputs"`
+	// Pipelines
+	// pipelines selected by redis list key, the first matched pipeline is used and unmatched keys are rejected
+	// if no pipeline is configured, all keys are accepted with global settings
+	Pipelines []PipelineOptions `yaml:"pipelines"`
 	// TimeOffset
 	// generally timezone information is missing from log files, you may need set a offset to fix it
 	// for 'Asia/Shanghai', set TimeOffset to -8
 	TimeOffset int `yaml:"time_offset"`
+	// TimeZone
+	// IANA time zone like 'Asia/Shanghai' for timestamps without zone information, time_offset is used if not set
+	TimeZone string `yaml:"time_zone"`
+	// TimeZones
+	// time zones by env, project and hostname pattern, precedence is hostname pattern > project > env > time_zone
+	TimeZones TimeZonesOptions `yaml:"time_zones"`
+	// Sources
+	// source path patterns tried in order, falls back to '.../{env}/{topic}/{project}.xxx.log'
+	Sources []SourceOptions `yaml:"sources"`
+	// TimestampLayouts
+	// ordered layouts to parse the timestamp prefix of messages, defaults to ['xlog']
+	// built-in layouts are 'xlog', 'iso8601', 'nginx', 'epoch' and 'epoch_ms', others are treated as Go time layouts,
+	// time_offset is skipped if the layout carries zone information
+	TimestampLayouts []string `yaml:"timestamp_layouts"`
+	// Timestamps
+	// the first matched rule overrides global timestamp layouts
+	Timestamps []TimestampOptions `yaml:"timestamps"`
+	// Logfmt
+	// the first matched options parse key=value pairs of the remaining message into extra, not for json topics
+	Logfmt []LogfmtOptions `yaml:"logfmt"`
+	// AccessLogs
+	// the first matched options parse the remaining message as access log into extra
+	AccessLogs []AccessLogOptions `yaml:"access_logs"`
+	// Processors
+	// ordered processors between record conversion and queueing
+	Processors []ProcessorOptions `yaml:"processors"`
+	// Enrich
+	// host inventory, geoip and user agent enrichment of records kept by rules and sampling, before redaction
+	Enrich EnrichOptions `yaml:"enrich"`
+	// DocumentIDFields
+	// fields to compute document ids, 'source', 'offset' and 'timestamp' are supported besides record and extra fields,
+	// defaults to ['hostname', 'source', 'offset', 'timestamp', 'crid', 'message'], with defaults only records carrying
+	// a beat offset get deterministic ids, identical lines without offset can not be told apart
+	DocumentIDFields []string `yaml:"document_id_fields"`
+	// Limits
+	// size limits of events, oversized events are truncated instead of dropped
+	Limits LimitOptions `yaml:"limits"`
+	// Rules
+	// the first matched rule drops, keeps or routes the record after processors, ignore and enforce_keyword
+	// are checked unless the matched rule is 'keep'
+	Rules []RuleOptions `yaml:"rules"`
+	// Sampling
+	// the first matched sampling options decide whether to keep the record after rules
+	Sampling []SamplingOptions `yaml:"sampling"`
+	// Redact
+	// redaction of message and extra before records are queued
+	Redact RedactOptions `yaml:"redact"`
 	// EnforceKeyword
 	// topic should be keyword enforced
 	EnforceKeyword []string `yaml:"enforce_keyword"`
 	// Ignore
 	// topic should be ignored
 	Ignore []string `yaml:"ignore"`
+
+	locations  map[string]*time.Location
+	processors []Processor
+}
+
+// BackpressureOptions high- and low-water marks of queues, a zero high-water mark disables the check
+// above any high-water mark, RPUSH/LPUSH returns an error, HTTP returns 503, lumberjack, syslog over TCP and pull
+// stop reading, syslog over UDP drops messages and counts them; pushes resume once all marks are below low-water marks
+type BackpressureOptions struct {
+	// HighDepth, LowDepth
+	// total depth of queues, low-water mark defaults to 80% of high-water mark
+	HighDepth int64 `yaml:"high_depth"`
+	LowDepth  int64 `yaml:"low_depth"`
+	// HighBytes, LowBytes
+	// total bytes of queue files on disk, low-water mark defaults to 80% of high-water mark
+	HighBytes int64 `yaml:"high_bytes"`
+	LowBytes  int64 `yaml:"low_bytes"`
+}
+
+// TLSOptions options for TLS
+type TLSOptions struct {
+	// Cert, Key
+	// PEM encoded certificate and private key files
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+	// ClientCA
+	// PEM encoded CA certificates to verify client certificates,
+	// CN of verified client certificate is used as the authenticated sender
+	ClientCA string `yaml:"client_ca"`
+	// RequireClientCert
+	// reject connections without a verified client certificate
+	RequireClientCert bool `yaml:"require_client_cert"`
+	// MinVersion
+	// minimum TLS version, one of '1.0', '1.1', '1.2' and '1.3', defaults to '1.2'
+	MinVersion string `yaml:"min_version"`
+}
+
+// AuthOptions options for authentication
+type AuthOptions struct {
+	// Password
+	// password for the legacy form 'AUTH password', authenticated as user 'default'
+	Password string `yaml:"password"`
+	// Users
+	// user to password map for the form 'AUTH user password', user names are used as tenants and may only contain
+	// letters, digits, '_', '.' and '-', CN of client certificates are sanitized likewise
+	Users map[string]string `yaml:"users"`
+	// TenantIndex
+	// prefix elasticsearch index names with authenticated user
+	TenantIndex bool `yaml:"tenant_index"`
+}
+
+// PipelineOptions options for a pipeline
+type PipelineOptions struct {
+	// Key
+	// redis list key or glob pattern, for example 'xlog', 'audit-*'
+	Key string `yaml:"key"`
+	// IndexPrefix
+	// prefix of elasticsearch index names
+	IndexPrefix string `yaml:"index_prefix"`
+	// TimeOffset
+	// overrides global time_offset if set
+	TimeOffset *int `yaml:"time_offset"`
+	// EnforceKeyword, Ignore
+	// topics should be keyword enforced or ignored, in addition to global settings
+	EnforceKeyword []string `yaml:"enforce_keyword"`
+	Ignore         []string `yaml:"ignore"`
+	// Output
+	// name of output, defaults to 'default'
+	Output string `yaml:"output"`
+}
+
+// HTTPOptions options for HTTP ingestion endpoint
+type HTTPOptions struct {
+	// Bind
+	// bind address for HTTP ingestion, disabled if empty
+	// POST a single event or newline-delimited events to '/{key}', key defaults to 'xlog'
+	Bind string `yaml:"bind"`
+	// MaxBody
+	// maximum size of request body in bytes, defaults to 32mb
+	MaxBody int `yaml:"max_body"`
+}
+
+// SyslogOptions options for syslog input
+type SyslogOptions struct {
+	// UDP
+	// bind address for syslog over UDP, disabled if empty
+	UDP string `yaml:"udp"`
+	// TCP
+	// bind address for syslog over TCP, both octet-counted and newline framing are supported, disabled if empty
+	TCP string `yaml:"tcp"`
+	// Key
+	// key to select pipeline, defaults to 'syslog', must match a pipeline if pipelines are configured
+	Key string `yaml:"key"`
+	// Env, Topic, Project
+	// default env, topic and project of syslog records, project defaults to app-name
+	Env     string `yaml:"env"`
+	Topic   string `yaml:"topic"`
+	Project string `yaml:"project"`
+	// Rules
+	// the first matched rule overrides default env, topic and project
+	Rules []SyslogRule `yaml:"rules"`
+}
+
+// SyslogRule rule to resolve env, topic and project of syslog records
+type SyslogRule struct {
+	// Hostname, AppName
+	// glob patterns, empty matches all
+	Hostname string `yaml:"hostname"`
+	AppName  string `yaml:"app_name"`
+	// Facility
+	// facility name like 'local0', empty matches all
+	Facility string `yaml:"facility"`
+	// Env, Topic, Project
+	// override if not empty
+	Env     string `yaml:"env"`
+	Topic   string `yaml:"topic"`
+	Project string `yaml:"project"`
+}
+
+// LumberjackOptions options for lumberjack v2 input
+type LumberjackOptions struct {
+	// Bind
+	// bind address for lumberjack v2 protocol, disabled if empty
+	Bind string `yaml:"bind"`
+	// Key
+	// key to select pipeline, defaults to 'xlog', must match a pipeline if pipelines are configured
+	Key string `yaml:"key"`
+	// MaxWindow
+	// max number of events in a window, larger windows are rejected, defaults to 4096
+	MaxWindow int `yaml:"max_window"`
+}
+
+// PullOptions options for pulling from upstream redis
+type PullOptions struct {
+	// Addr
+	// address of upstream redis, for example 127.0.0.1:6379
+	Addr string `yaml:"addr"`
+	// Password
+	// password for AUTH, skipped if empty
+	Password string `yaml:"password"`
+	// DB
+	// database to SELECT
+	DB int `yaml:"db"`
+	// Keys
+	// list keys to drain, defaults to 'xlog', each must match a pipeline if pipelines are configured
+	Keys []string `yaml:"keys"`
+	// Batch
+	// maximum events pulled at once, defaults to 100
+	Batch int `yaml:"batch"`
+	// Timeout
+	// BLPOP timeout in seconds, defaults to 5
+	Timeout int `yaml:"timeout"`
+}
+
+// SourceOptions source path pattern
+type SourceOptions struct {
+	// Pattern
+	// regular expression with named captures, 'env', 'topic', 'project' and 'hostname' are assigned to record fields,
+	// others are assigned to extra, for example '^/var/log/containers/(?P<project>[^_]+)_(?P<env>[^_]+)_'
+	Pattern string `yaml:"pattern"`
+	// Defaults
+	// static values used if not captured, pattern is skipped if env, topic or project is still missing
+	Defaults map[string]string `yaml:"defaults"`
+
+	regexp *regexp.Regexp
+}
+
+// ProcessorOptions options for a processor, field names other than 'message', 'env', 'topic', 'project', 'hostname',
+// 'crid', 'keyword' and 'tenant' refer to extra fields, a leading 'x_' is stripped
+type ProcessorOptions struct {
+	// Type
+	// one of 'drop_if', 'rename', 'set', 'logfmt', 'json', 'regex', 'truncate', 'redact' and 'fingerprint'
+	Type string `yaml:"type"`
+	// Topic, Project
+	// glob patterns to limit the processor, empty matches all
+	Topic   string `yaml:"topic"`
+	Project string `yaml:"project"`
+	// Field
+	// field to process, defaults to 'message'
+	Field string `yaml:"field"`
+	// Target
+	// target field of 'rename', or of 'fingerprint' defaults to 'x_fingerprint', template is stored to target with
+	// suffix '_template'
+	Target string `yaml:"target"`
+	// Value
+	// value of 'set'
+	Value string `yaml:"value"`
+	// Pattern
+	// regular expression of 'drop_if', 'redact' and 'regex', named captures of 'regex' are assigned to fields
+	Pattern string `yaml:"pattern"`
+	// Preset, Action, Replacement
+	// built-in pattern, action and replacement of 'redact', see RedactRule
+	Preset      string `yaml:"preset"`
+	Action      string `yaml:"action"`
+	Replacement string `yaml:"replacement"`
+	// Max
+	// maximum bytes of 'truncate'
+	Max int `yaml:"max"`
+	// Keep
+	// keep the original field of 'logfmt' and 'json'
+	Keep bool `yaml:"keep"`
+	// Convert
+	// convert numeric values of 'logfmt'
+	Convert bool `yaml:"convert"`
+	// Masks
+	// built-in masks of 'fingerprint' applied in order, defaults to ['email', 'uuid', 'quoted', 'hex', 'number']
+	Masks []string `yaml:"masks"`
+	// Rules
+	// custom normalization rules of 'fingerprint', applied before built-in masks
+	Rules []FingerprintRule `yaml:"rules"`
+}
+
+// FingerprintRule a normalization rule of fingerprint processor
+type FingerprintRule struct {
+	// Pattern, Replacement
+	// regular expression and literal replacement
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+// LimitOptions size limits of events, records are marked with 'truncated' and 'original_length' of message
+type LimitOptions struct {
+	// MaxRaw
+	// raw events larger are kept by cutting the message and then the largest extra fields to fit approximately,
+	// defaults to 1000000 bytes
+	MaxRaw int `yaml:"max_raw"`
+	// MaxMessage
+	// maximum bytes of message, defaults to max_raw
+	MaxMessage int `yaml:"max_message"`
+	// MaxExtra
+	// maximum bytes of each extra field, non-string values are measured and cut as JSON, unlimited if zero
+	MaxExtra int `yaml:"max_extra"`
+	// HardDrop
+	// raw events larger are saved as dead letters with reason 'oversize', defaults to 16mb
+	HardDrop int `yaml:"hard_drop"`
+}
+
+// EnrichOptions options for enrichment from local databases
+type EnrichOptions struct {
+	// GeoIP
+	// MaxMind DB files for geoip enrichment
+	GeoIP GeoIPOptions `yaml:"geoip"`
+	// UserAgent
+	// user agent parsing
+	UserAgent UserAgentOptions `yaml:"user_agent"`
+	// Inventory
+	// host attributes from a static file
+	Inventory InventoryOptions `yaml:"inventory"`
+}
+
+// InventoryOptions host attributes like datacenter, rack and owner team merged into extra by hostname
+type InventoryOptions struct {
+	// File
+	// YAML file of hostname or glob to attributes, or CSV file with header 'hostname,attr1,attr2,...',
+	// exact hostnames take precedence over globs, longer globs over shorter ones, disabled if empty
+	File string `yaml:"file"`
+	// Reload
+	// interval in seconds to check the file for changes, defaults to 60
+	Reload int `yaml:"reload"`
+	// TagUnknown
+	// set 'x_unknown_host' for hosts not in inventory, distinct unknown hosts per minute are always counted in stats
+	TagUnknown bool `yaml:"tag_unknown"`
+}
+
+// GeoIPOptions adds 'geo_country', 'geo_city', 'geo_location' from city database and 'asn', 'as_org' from ASN database
+type GeoIPOptions struct {
+	// Field
+	// field of ip address, defaults to 'x_client_ip'
+	Field string `yaml:"field"`
+	// City, ASN
+	// GeoIP2/GeoLite2 City and ASN database files, disabled if empty
+	City string `yaml:"city"`
+	ASN  string `yaml:"asn"`
+	// Reload
+	// interval in seconds to check database files for changes, defaults to 60
+	Reload int `yaml:"reload"`
+}
+
+// UserAgentOptions adds 'ua_browser', 'ua_browser_version', 'ua_os', 'ua_os_version' and 'ua_device'
+type UserAgentOptions struct {
+	// Field
+	// field of user agent, for example 'x_user_agent', disabled if empty
+	Field string `yaml:"field"`
+}
+
+// RuleOptions routing rule, all conditions set must match
+type RuleOptions struct {
+	// Env, Project, Topic, Hostname
+	// glob patterns
+	Env      string `yaml:"env"`
+	Project  string `yaml:"project"`
+	Topic    string `yaml:"topic"`
+	Hostname string `yaml:"hostname"`
+	// Message
+	// regular expression of message
+	Message string `yaml:"message"`
+	// Keyword
+	// keyword contains
+	Keyword string `yaml:"keyword"`
+	// Extra
+	// extra field to regular expression, for example 'x_status: ^5'
+	Extra map[string]string `yaml:"extra"`
+	// Action
+	// one of 'drop', 'keep' and 'route'
+	Action string `yaml:"action"`
+	// Index, Output
+	// index name without date suffix and name of output of 'route', original ones are used if empty
+	Index  string `yaml:"index"`
+	Output string `yaml:"output"`
+
+	message *regexp.Regexp
+	extra   map[string]*regexp.Regexp
+}
+
+// SamplingOptions sampling by env, topic and project, kept records carry 'x_sample_rate' to re-scale counts
+type SamplingOptions struct {
+	// Env, Topic, Project
+	// glob patterns, empty matches all
+	Env     string `yaml:"env"`
+	Topic   string `yaml:"topic"`
+	Project string `yaml:"project"`
+	// Percent
+	// fixed-ratio mode, percentage of records to keep
+	Percent float64 `yaml:"percent"`
+	// HashCrid
+	// keep or drop records with the same crid together in fixed-ratio mode
+	HashCrid bool `yaml:"hash_crid"`
+	// Rate
+	// rate-cap mode, maximum records per second for each env, topic and project,
+	// sample rate is estimated from the previous second
+	Rate int64 `yaml:"rate"`
+
+	windows *sampleWindows
+}
+
+// RedactOptions redaction of secrets and PII, counters per rule are collected in stats
+type RedactOptions struct {
+	// Rules
+	// rules applied in order to message and string values of extra
+	Rules []RedactRule `yaml:"rules"`
+	// DenyFields
+	// names of extra fields to redact entirely, nested fields included, case insensitive
+	DenyFields []string `yaml:"deny_fields"`
+	// DenyAction
+	// one of 'mask', 'hash' and 'drop' for deny fields, defaults to 'mask'
+	DenyAction string `yaml:"deny_action"`
+}
+
+// RedactRule a redaction rule
+type RedactRule struct {
+	// Name
+	// name of counter in stats, defaults to preset
+	Name string `yaml:"name"`
+	// Preset, Pattern
+	// one of built-in patterns 'bearer', 'email', 'cn_mobile' and 'card' (Luhn-valid numbers only),
+	// or a regular expression
+	Preset  string `yaml:"preset"`
+	Pattern string `yaml:"pattern"`
+	// Action
+	// one of 'mask', 'hash' and 'drop', defaults to 'mask'
+	Action string `yaml:"action"`
+	// Replacement
+	// replacement of 'mask', defaults to '***'
+	Replacement string `yaml:"replacement"`
+
+	regexp   *regexp.Regexp
+	validate func(string) bool
+}
+
+// TimeZonesOptions time zones by env, project and hostname pattern
+type TimeZonesOptions struct {
+	// Envs, Projects
+	// env or project to IANA time zone map
+	Envs     map[string]string `yaml:"envs"`
+	Projects map[string]string `yaml:"projects"`
+	// Hosts
+	// the first matched hostname pattern is used
+	Hosts []HostTimeZoneOptions `yaml:"hosts"`
+}
+
+// HostTimeZoneOptions time zone for hostname pattern
+type HostTimeZoneOptions struct {
+	Hostname string `yaml:"hostname"`
+	TimeZone string `yaml:"time_zone"`
+}
+
+// TimestampOptions timestamp layouts for topic and project
+type TimestampOptions struct {
+	// Topic, Project
+	// glob patterns, empty matches all
+	Topic   string `yaml:"topic"`
+	Project string `yaml:"project"`
+	// Layouts
+	// ordered timestamp layouts
+	Layouts []string `yaml:"layouts"`
+}
+
+// LogfmtOptions logfmt parsing for topic and project
+type LogfmtOptions struct {
+	// Topic, Project
+	// glob patterns, empty matches all
+	Topic   string `yaml:"topic"`
+	Project string `yaml:"project"`
+	// KeepMessage
+	// keep the original message, otherwise pairs are removed from message
+	KeepMessage bool `yaml:"keep_message"`
+	// ConvertNumbers
+	// convert numeric values to numbers
+	ConvertNumbers bool `yaml:"convert_numbers"`
+}
+
+// AccessLogOptions access log parsing for topic and project, extracted fields are 'client_ip', 'user', 'time',
+// 'method', 'path', 'protocol', 'status', 'bytes', 'referrer', 'user_agent', 'request_time' and other variable names
+type AccessLogOptions struct {
+	// Topic, Project
+	// glob patterns, empty matches all
+	Topic   string `yaml:"topic"`
+	Project string `yaml:"project"`
+	// Format
+	// one of 'common', 'combined' and 'nginx', or a nginx log_format string like '$remote_addr [$time_local] "$request"'
+	Format string `yaml:"format"`
+	// UseTime
+	// use $time_local or $time_iso8601 as the record timestamp, the timestamp prefix of message is optional
+	UseTime bool `yaml:"use_time"`
+
+	regexp *regexp.Regexp
 }
 
 // ElasticsearchOptions options for ElasticSearch
@@ -144,6 +853,19 @@ type ElasticsearchOptions struct {
 	// by default, batch size is 100 and a timeout of 10s
 	// that means xlogd will perform a bulk write once cached records reached 100, or been idle for 10 seconds
 	Batch BatchOptions `yaml:"batch"`
+	// Retry
+	// failed bulk commits and retryable items (429, 502, 503, 504) are retried with exponential backoff
+	Retry RetryOptions `yaml:"retry"`
+}
+
+// RetryOptions options for bulk retrying
+type RetryOptions struct {
+	// MinBackoff
+	// initial backoff in milliseconds
+	MinBackoff int `yaml:"min_backoff"`
+	// MaxBackoff
+	// maximum backoff in milliseconds
+	MaxBackoff int `yaml:"max_backoff"`
 }
 
 // BatchOptions options for batch processing
@@ -159,6 +881,37 @@ type BatchOptions struct {
 	Burst int `yaml:"burst"`
 }
 
+func checkElasticsearchOptions(opt *ElasticsearchOptions) (err error) {
+	// check elasticsearch urls
+	if len(opt.URLs) == 0 {
+		err = errors.New("no elasticsearch urls")
+		return
+	}
+	// check batch size
+	if opt.Batch.Size <= 0 {
+		opt.Batch.Size = 100
+	}
+	// check batch limit
+	if opt.Batch.Rate <= 0 {
+		opt.Batch.Rate = 1000
+	}
+	// check batch burst
+	if opt.Batch.Burst <= 0 {
+		opt.Batch.Burst = 10000
+	}
+	// check retry backoff
+	if opt.Retry.MinBackoff <= 0 {
+		opt.Retry.MinBackoff = 500
+	}
+	if opt.Retry.MaxBackoff < opt.Retry.MinBackoff {
+		opt.Retry.MaxBackoff = 30000
+	}
+	if opt.Retry.MaxBackoff < opt.Retry.MinBackoff {
+		opt.Retry.MaxBackoff = opt.Retry.MinBackoff
+	}
+	return
+}
+
 // LoadOptions load options from yaml file
 func LoadOptions(filename string) (opt Options, err error) {
 	var buf []byte
@@ -173,26 +926,229 @@ func LoadOptions(filename string) (opt Options, err error) {
 	if len(opt.DataDir) == 0 {
 		opt.DataDir = "/data/xlogd"
 	}
+	// check failure_dir
+	if len(opt.FailureDir) == 0 {
+		opt.FailureDir = filepath.Join(opt.DataDir, "failed")
+	}
+	// check dead_letter_dir
+	if len(opt.DeadLetterDir) == 0 {
+		opt.DeadLetterDir = filepath.Join(opt.DataDir, "dead")
+	}
 	// check bind
 	if len(opt.Bind) == 0 {
 		opt.Bind = "0.0.0.0:6379"
 	}
-	// check elasticsearch urls
-	if len(opt.Elasticsearch.URLs) == 0 {
-		err = errors.New("no elasticsearch urls")
+	// check time zones
+	if err = opt.loadLocations(); err != nil {
 		return
 	}
-	// check batch size
-	if opt.Elasticsearch.Batch.Size <= 0 {
-		opt.Elasticsearch.Batch.Size = 100
+	// check source patterns
+	for i := range opt.Sources {
+		if err = opt.Sources[i].compile(); err != nil {
+			return
+		}
 	}
-	// check batch limit
-	if opt.Elasticsearch.Batch.Rate <= 0 {
-		opt.Elasticsearch.Batch.Rate = 1000
+	// check processors
+	for _, po := range opt.Processors {
+		var p Processor
+		if p, err = NewProcessor(po); err != nil {
+			return
+		}
+		opt.processors = append(opt.processors, p)
 	}
-	// check batch burst
-	if opt.Elasticsearch.Batch.Burst <= 0 {
-		opt.Elasticsearch.Batch.Burst = 10000
+	// check backpressure low-water marks
+	if opt.Backpressure.LowDepth <= 0 || opt.Backpressure.LowDepth > opt.Backpressure.HighDepth {
+		opt.Backpressure.LowDepth = opt.Backpressure.HighDepth * 8 / 10
+	}
+	if opt.Backpressure.LowBytes <= 0 || opt.Backpressure.LowBytes > opt.Backpressure.HighBytes {
+		opt.Backpressure.LowBytes = opt.Backpressure.HighBytes * 8 / 10
+	}
+	// check http max body
+	if opt.HTTP.MaxBody <= 0 {
+		opt.HTTP.MaxBody = 32 * 1024 * 1024
+	}
+	// check lumberjack key
+	if len(opt.Lumberjack.Key) == 0 {
+		opt.Lumberjack.Key = "xlog"
+	}
+	if opt.Lumberjack.MaxWindow <= 0 {
+		opt.Lumberjack.MaxWindow = 4096
+	}
+	// check syslog key, env and topic
+	if len(opt.Syslog.Key) == 0 {
+		opt.Syslog.Key = "syslog"
+	}
+	if len(opt.Syslog.Env) == 0 {
+		opt.Syslog.Env = "default"
+	}
+	if len(opt.Syslog.Topic) == 0 {
+		opt.Syslog.Topic = "syslog"
+	}
+	// check pull options
+	for i := range opt.Pull {
+		if len(opt.Pull[i].Addr) == 0 {
+			err = errors.New("no addr for pull")
+			return
+		}
+		if len(opt.Pull[i].Keys) == 0 {
+			opt.Pull[i].Keys = []string{"xlog"}
+		}
+		if opt.Pull[i].Batch <= 0 {
+			opt.Pull[i].Batch = 100
+		}
+		if opt.Pull[i].Timeout <= 0 {
+			opt.Pull[i].Timeout = 5
+		}
+	}
+	// check elasticsearch options
+	if err = checkElasticsearchOptions(&opt.Elasticsearch); err != nil {
+		return
+	}
+	// check outputs
+	for name, output := range opt.Outputs {
+		if name == defaultOutput {
+			err = errors.New("output name 'default' is reserved")
+			return
+		}
+		if err = checkElasticsearchOptions(&output); err != nil {
+			err = errors.New("output '" + name + "': " + err.Error())
+			return
+		}
+		opt.Outputs[name] = output
+	}
+	// check auth users
+	for user := range opt.Auth.Users {
+		if !isValidTenant(user) {
+			err = errors.New("auth: invalid user name '" + user + "'")
+			return
+		}
+	}
+	// check limits
+	if opt.Limits.MaxRaw <= 0 {
+		opt.Limits.MaxRaw = 1000000
+	}
+	if opt.Limits.MaxMessage <= 0 {
+		opt.Limits.MaxMessage = opt.Limits.MaxRaw
+	}
+	if opt.Limits.HardDrop <= 0 {
+		opt.Limits.HardDrop = 16 * 1024 * 1024
+	}
+	if opt.Limits.HardDrop < opt.Limits.MaxRaw {
+		err = errors.New("limits: hard_drop should not be less than max_raw")
+		return
+	}
+	// check enrich
+	if len(opt.Enrich.GeoIP.Field) == 0 {
+		opt.Enrich.GeoIP.Field = "x_client_ip"
+	}
+	if opt.Enrich.GeoIP.Reload <= 0 {
+		opt.Enrich.GeoIP.Reload = 60
+	}
+	if opt.Enrich.Inventory.Reload <= 0 {
+		opt.Enrich.Inventory.Reload = 60
+	}
+	// check rules
+	for i := range opt.Rules {
+		switch opt.Rules[i].Action {
+		case RuleDrop, RuleKeep, RuleRoute:
+		default:
+			err = errors.New("unknown action '" + opt.Rules[i].Action + "' for rule")
+			return
+		}
+		if _, ok := opt.Outputs[opt.Rules[i].Output]; !ok && len(opt.Rules[i].Output) > 0 && opt.Rules[i].Output != defaultOutput {
+			err = errors.New("unknown output '" + opt.Rules[i].Output + "' for rule")
+			return
+		}
+		if err = opt.Rules[i].compile(); err != nil {
+			return
+		}
+	}
+	// check access logs
+	for i := range opt.AccessLogs {
+		if err = opt.AccessLogs[i].compile(); err != nil {
+			return
+		}
+	}
+	// check logfmt
+	for _, l := range opt.Logfmt {
+		if _, err = path.Match(l.Topic, ""); err != nil {
+			return
+		}
+		if _, err = path.Match(l.Project, ""); err != nil {
+			return
+		}
+	}
+	// check redact
+	for i := range opt.Redact.Rules {
+		if err = opt.Redact.Rules[i].compile(); err != nil {
+			return
+		}
+		if len(opt.Redact.Rules[i].Name) == 0 {
+			opt.Redact.Rules[i].Name = fmt.Sprintf("rule-%d", i)
+		}
+	}
+	if len(opt.Redact.DenyAction) == 0 {
+		opt.Redact.DenyAction = RedactMask
+	}
+	if err = checkRedactAction(opt.Redact.DenyAction); err != nil {
+		return
+	}
+	// check sampling
+	for i := range opt.Sampling {
+		if err = opt.Sampling[i].check(); err != nil {
+			return
+		}
+	}
+	// check pipelines
+	for i := range opt.Pipelines {
+		if len(opt.Pipelines[i].Key) == 0 {
+			err = errors.New("no key for pipeline")
+			return
+		}
+		if _, err = path.Match(opt.Pipelines[i].Key, ""); err != nil {
+			return
+		}
+		if opt.Pipelines[i].TimeOffset == nil {
+			offset := opt.TimeOffset
+			opt.Pipelines[i].TimeOffset = &offset
+		}
+		if len(opt.Pipelines[i].Output) == 0 {
+			opt.Pipelines[i].Output = defaultOutput
+		}
+		if _, ok := opt.Outputs[opt.Pipelines[i].Output]; !ok && opt.Pipelines[i].Output != defaultOutput {
+			err = errors.New("unknown output '" + opt.Pipelines[i].Output + "' for pipeline")
+			return
+		}
+	}
+	// check keys of enabled inputs have pipelines, otherwise all their events are dead letters
+	if (len(opt.Syslog.UDP) > 0 || len(opt.Syslog.TCP) > 0) && !opt.hasPipeline(opt.Syslog.Key) {
+		err = errors.New("no pipeline for syslog key '" + opt.Syslog.Key + "'")
+		return
+	}
+	if len(opt.Lumberjack.Bind) > 0 && !opt.hasPipeline(opt.Lumberjack.Key) {
+		err = errors.New("no pipeline for lumberjack key '" + opt.Lumberjack.Key + "'")
+		return
+	}
+	for _, po := range opt.Pull {
+		for _, key := range po.Keys {
+			if !opt.hasPipeline(key) {
+				err = errors.New("no pipeline for pull key '" + key + "'")
+				return
+			}
+		}
 	}
 	return
 }
+
+// hasPipeline check key is accepted by configured pipelines, all keys are accepted if no pipeline is configured
+func (opt Options) hasPipeline(key string) bool {
+	if len(opt.Pipelines) == 0 {
+		return true
+	}
+	for _, p := range opt.Pipelines {
+		if ok, _ := path.Match(p.Key, key); ok {
+			return true
+		}
+	}
+	return false
+}
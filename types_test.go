@@ -1,6 +1,10 @@
 package main
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -37,19 +41,19 @@ func TestRecord_Map(t *testing.T) {
 
 func TestEvent_ToRecord(t *testing.T) {
 	var be Event
-	_, ok := be.ToRecord(0)
-	if ok {
+	_, reason, ok := be.ToRecord(0)
+	if ok || reason != ReasonBadSource {
 		t.Fatal("failed")
 	}
 	be.Message = "[2018/07/20 15:03:00.000] fakeK[fake] K[world] hello world CRID[aaa] KW[hello]"
-	_, ok = be.ToRecord(0)
+	_, _, ok = be.ToRecord(0)
 	if ok {
 		t.Fatal("failed")
 	}
 	be.Source = "/tmp/test2/test3/test1.20180719.log"
 	be.Beat.Hostname = "test.test"
 	var r Record
-	if r, ok = be.ToRecord(0); !ok {
+	if r, _, ok = be.ToRecord(0); !ok {
 		t.Fatal("failed")
 	}
 	if !r.Timestamp.Equal(time.Date(2018, time.July, 20, 15, 3, 0, 0, time.UTC)) {
@@ -80,19 +84,24 @@ func TestEvent_ToRecord(t *testing.T) {
 
 func TestEvent_ToRecord_JSON(t *testing.T) {
 	var be Event
-	_, ok := be.ToRecord(0)
+	_, _, ok := be.ToRecord(0)
 	if ok {
 		t.Fatal("failed")
 	}
 	be.Message = `[2018/07/20 15:03:00.000] {"crid":"aaa", "topic":"x-test3", "duration":"aa"}`
-	_, ok = be.ToRecord(0)
+	_, _, ok = be.ToRecord(0)
 	if ok {
 		t.Fatal("failed")
 	}
 	be.Source = "/tmp/test2/_json_/test1.20180719.log"
 	be.Beat.Hostname = "test.test"
+	be.Message = `[2018/07/20 15:03:00.000] {"crid":"aaa"}`
+	if _, reason, ok := be.ToRecord(0); ok || reason != ReasonMissingTopic {
+		t.Fatal("missing topic")
+	}
+	be.Message = `[2018/07/20 15:03:00.000] {"crid":"aaa", "topic":"x-test3", "duration":"aa"}`
 	var r Record
-	if r, ok = be.ToRecord(0); !ok {
+	if r, _, ok = be.ToRecord(0); !ok {
 		t.Fatal("failed")
 	}
 	if !r.Timestamp.Equal(time.Date(2018, time.July, 20, 15, 3, 0, 0, time.UTC)) {
@@ -128,3 +137,189 @@ func TestLoadOptions(t *testing.T) {
 	}
 	t.Log(opt)
 }
+
+func TestLoadOptions_InputPipelines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xlogd-options")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "xlogd.yml")
+	base := "elasticsearch:\n  urls: ['http://127.0.0.1:9200']\nsyslog:\n  udp: 127.0.0.1:5514\n"
+	if err = ioutil.WriteFile(file, []byte(base+"pipelines:\n  - key: xlog\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadOptions(file); err == nil {
+		t.Fatal("should fail without syslog pipeline")
+	}
+	if err = ioutil.WriteFile(file, []byte(base+"pipelines:\n  - key: xlog\n  - key: sys*\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadOptions(file); err != nil {
+		t.Fatal(err)
+	}
+	// lumberjack and pull keys
+	for _, content := range []string{
+		"lumberjack:\n  bind: 127.0.0.1:5044\n  key: beats\n",
+		"pull:\n  - addr: 127.0.0.1:6379\n    keys: [xlog, audit]\n",
+	} {
+		if err = ioutil.WriteFile(file, []byte(base+"pipelines:\n  - key: xlog\n  - key: sys*\n"+content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = LoadOptions(file); err == nil {
+			t.Fatal("should fail without pipeline", content)
+		}
+	}
+}
+
+func TestEvent_ToRecord_ECS(t *testing.T) {
+	var be Event
+	raw := `{
+		"@timestamp": "2019-03-20T07:03:00.000Z",
+		"message": "[2018/07/20 15:03:00.000] CRID[aaa] hello",
+		"source": {"ip": "10.0.0.1"},
+		"log": {"offset": 100, "file": {"path": "/var/log/test2/test3/test1.log"}},
+		"host": {"name": "test.host"},
+		"agent": {"hostname": "test.agent"},
+		"fields": {"team": "infra"},
+		"tags": ["beta"],
+		"cloud": {"provider": "aws"}
+	}`
+	if err := json.Unmarshal([]byte(raw), &be); err != nil {
+		t.Fatal(err)
+	}
+	r, _, ok := be.ToRecord(0)
+	if !ok {
+		t.Fatal("failed")
+	}
+	if r.Hostname != "test.host" {
+		t.Fatal("hostname", r.Hostname)
+	}
+	if r.Env != "test2" || r.Topic != "test3" || r.Project != "test1" {
+		t.Fatal("source", r.Env, r.Topic, r.Project)
+	}
+	if r.Crid != "aaa" || r.Message != "CRID[aaa] hello" {
+		t.Fatal("message", r.Crid, r.Message)
+	}
+	if r.Extra["fields"].(map[string]interface{})["team"] != "infra" || r.Extra["tags"].([]string)[0] != "beta" || r.Extra["cloud"] == nil {
+		t.Fatal("extra", r.Extra)
+	}
+	// filebeat 6.3 host string
+	be = Event{}
+	if err := json.Unmarshal([]byte(`{"host": "legacy.host", "agent": {"hostname": "test.agent"}}`), &be); err != nil {
+		t.Fatal(err)
+	}
+	if be.Host.Name != "legacy.host" {
+		t.Fatal("host string", be.Host.Name)
+	}
+}
+
+func TestEvent_ToRecord_Logfmt(t *testing.T) {
+	options = Options{Logfmt: []LogfmtOptions{
+		{Topic: "raw"},
+		{Project: "test1", ConvertNumbers: true},
+	}}
+	defer func() {
+		options = Options{}
+	}()
+	be := Event{
+		Source:  "/tmp/test2/test3/test1.20180719.log",
+		Message: `[2018/07/20 15:03:00.000] CRID[aaa] order paid order_id=42 amount=9.5 note="gift card"`,
+	}
+	r, _, ok := be.ToRecord(0)
+	if !ok {
+		t.Fatal("failed")
+	}
+	if r.Crid != "aaa" || r.Message != "CRID[aaa] order paid" {
+		t.Fatal("message", r.Crid, r.Message)
+	}
+	if r.Extra["order_id"] != int64(42) || r.Extra["amount"] != 9.5 || r.Extra["note"] != "gift card" {
+		t.Fatal("extra", r.Extra)
+	}
+	options.Logfmt[1].KeepMessage = true
+	options.Logfmt[1].ConvertNumbers = false
+	if r, _, _ = be.ToRecord(0); r.Message != `CRID[aaa] order paid order_id=42 amount=9.5 note="gift card"` || r.Extra["order_id"] != "42" {
+		t.Fatal("keep message", r.Message, r.Extra)
+	}
+	be.Source = "/tmp/test2/test3/test4.20180719.log"
+	if r, _, _ = be.ToRecord(0); r.Extra != nil {
+		t.Fatal("not matched", r.Extra)
+	}
+}
+
+func TestEvent_ToRecord_LogfmtSourceCapture(t *testing.T) {
+	options = Options{
+		Sources: []SourceOptions{{Pattern: `^/srv/(?P<service>[^/]+)/(?P<env>[^/]+)/(?P<topic>[^/]+)/(?P<project>[^.]+)\.log$`}},
+		Logfmt:  []LogfmtOptions{{Topic: "app"}},
+	}
+	defer func() {
+		options = Options{}
+	}()
+	if err := options.Sources[0].compile(); err != nil {
+		t.Fatal(err)
+	}
+	be := Event{Source: "/srv/api/prod/app/web.log", Message: "[2018/07/20 15:03:00.000] a=1 hello\tworld  service=other b=2"}
+	r, _, ok := be.ToRecord(0)
+	if !ok {
+		t.Fatal("failed")
+	}
+	if r.Extra["service"] != "api" || r.Extra["a"] != "1" || r.Extra["b"] != "2" {
+		t.Fatal("extra", r.Extra)
+	}
+	if r.Message != "hello\tworld  service=other" {
+		t.Fatalf("message %q", r.Message)
+	}
+	// no pairs keeps extra untouched
+	be.Message = "[2018/07/20 15:03:00.000] hello world"
+	if r, _, _ = be.ToRecord(0); len(r.Extra) != 1 || r.Extra["service"] != "api" || r.Message != "hello world" {
+		t.Fatal("no pairs", r.Extra, r.Message)
+	}
+}
+
+func TestRecord_DocumentID(t *testing.T) {
+	r := Record{Hostname: "test", Source: "/var/log/a.log", Crid: "aaa", Message: "hello"}
+	id := r.DocumentID(nil)
+	if len(id) != 32 || id != r.DocumentID(defaultDocumentIDFields) {
+		t.Fatal("default", id)
+	}
+	r2 := r
+	r2.Message = "hello!"
+	if r2.DocumentID(nil) == id {
+		t.Fatal("message should change id")
+	}
+	r2 = r
+	r2.Extra = map[string]interface{}{"seq": 1}
+	if r2.DocumentID(nil) != id || r2.DocumentID([]string{"x_seq"}) == r.DocumentID([]string{"x_seq"}) {
+		t.Fatal("fields")
+	}
+	// fields are separated
+	a := Record{Hostname: "ab", Crid: "c"}
+	b := Record{Hostname: "a", Crid: "bc"}
+	if a.DocumentID(nil) == b.DocumentID(nil) {
+		t.Fatal("separator")
+	}
+	// identical lines are told apart by offset
+	var o1, o2 int64 = 100, 200
+	a = Record{Hostname: "test", Source: "/var/log/a.log", Message: "hello", Offset: &o1}
+	b = a
+	b.Offset = &o2
+	if a.DocumentID(nil) == b.DocumentID(nil) {
+		t.Fatal("offset")
+	}
+}
+
+func TestEvent_ToRecord_Offset(t *testing.T) {
+	for _, raw := range []string{
+		`{"message":"[2018/07/20 15:03:00.000] hello","source":"/var/log/test/err/project.log","offset":42}`,
+		`{"message":"[2018/07/20 15:03:00.000] hello","log":{"file":{"path":"/var/log/test/err/project.log"},"offset":42}}`,
+	} {
+		var e Event
+		if err := json.Unmarshal([]byte(raw), &e); err != nil {
+			t.Fatal(err)
+		}
+		r, _, ok := e.ToRecord(0)
+		if !ok || r.Offset == nil || *r.Offset != 42 {
+			t.Fatal("offset", raw)
+		}
+	}
+}
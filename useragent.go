@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strings"
+)
+
+// UserAgent fields parsed from a user agent string
+type UserAgent struct {
+	Browser        string
+	BrowserVersion string
+	OS             string
+	OSVersion      string
+	Device         string
+}
+
+// browsers by product token, order matters since most browsers also claim 'Chrome' or 'Safari'
+var userAgentBrowsers = []struct {
+	token string
+	name  string
+}{
+	{"Edg/", "Edge"},
+	{"Edge/", "Edge"},
+	{"EdgA/", "Edge"},
+	{"EdgiOS/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Opera/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"UCBrowser/", "UC Browser"},
+	{"MicroMessenger/", "WeChat"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"MSIE ", "IE"},
+}
+
+// bot marks in lower case
+var userAgentBots = []string{"bot", "spider", "crawler", "curl/", "wget/", "python-requests/", "go-http-client/", "okhttp/", "java/"}
+
+// userAgentVersion extract version after token, until a character other than digits, '.' and '_'
+func userAgentVersion(ua string, token string) (string, bool) {
+	i := strings.Index(ua, token)
+	if i < 0 {
+		return "", false
+	}
+	v := ua[i+len(token):]
+	end := 0
+	for end < len(v) && (v[end] >= '0' && v[end] <= '9' || v[end] == '.' || v[end] == '_') {
+		end++
+	}
+	return strings.Replace(v[:end], "_", ".", -1), true
+}
+
+// parseUserAgent heuristically parse browser, OS and device of a user agent string,
+// device is one of 'desktop', 'mobile', 'tablet' and 'bot'
+func parseUserAgent(ua string) (u UserAgent) {
+	if len(ua) == 0 {
+		return
+	}
+	lower := strings.ToLower(ua)
+	// os
+	switch {
+	case strings.Contains(ua, "Windows NT"):
+		u.OS = "Windows"
+		v, _ := userAgentVersion(ua, "Windows NT ")
+		u.OSVersion = map[string]string{"10.0": "10", "6.3": "8.1", "6.2": "8", "6.1": "7", "6.0": "Vista", "5.1": "XP"}[v]
+		if len(u.OSVersion) == 0 {
+			u.OSVersion = v
+		}
+	case strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPad") || strings.Contains(ua, "iPod"):
+		u.OS = "iOS"
+		if v, ok := userAgentVersion(ua, "iPhone OS "); ok {
+			u.OSVersion = v
+		} else {
+			u.OSVersion, _ = userAgentVersion(ua, "CPU OS ")
+		}
+	case strings.Contains(ua, "Android"):
+		u.OS = "Android"
+		u.OSVersion, _ = userAgentVersion(ua, "Android ")
+	case strings.Contains(ua, "Mac OS X"):
+		u.OS = "macOS"
+		u.OSVersion, _ = userAgentVersion(ua, "Mac OS X ")
+	case strings.Contains(ua, "CrOS"):
+		u.OS = "Chrome OS"
+	case strings.Contains(ua, "Linux"):
+		u.OS = "Linux"
+	}
+	// browser
+	for _, b := range userAgentBrowsers {
+		if v, ok := userAgentVersion(ua, b.token); ok {
+			u.Browser, u.BrowserVersion = b.name, v
+			break
+		}
+	}
+	if len(u.Browser) == 0 {
+		if strings.Contains(ua, "Trident/") {
+			u.Browser = "IE"
+			u.BrowserVersion, _ = userAgentVersion(ua, "rv:")
+		} else if strings.Contains(ua, "Safari/") {
+			u.Browser = "Safari"
+			u.BrowserVersion, _ = userAgentVersion(ua, "Version/")
+		}
+	}
+	// device
+	for _, b := range userAgentBots {
+		if !strings.Contains(lower, b) {
+			continue
+		}
+		u.Device = "bot"
+		if len(u.Browser) == 0 {
+			// name of the product token containing the bot mark, or the first product token
+			tokens := strings.FieldsFunc(ua, func(r rune) bool { return r == ' ' || r == ';' || r == '(' || r == ')' })
+			var product string
+			if len(tokens) > 0 {
+				product = tokens[0]
+			}
+			for _, t := range tokens {
+				if strings.Contains(t, "/") && strings.Contains(strings.ToLower(t), strings.TrimSuffix(b, "/")) {
+					product = t
+					break
+				}
+			}
+			u.Browser = strings.SplitN(product, "/", 2)[0]
+			u.BrowserVersion, _ = userAgentVersion(product, u.Browser+"/")
+		}
+		return
+	}
+	switch {
+	case strings.Contains(ua, "iPad") || strings.Contains(lower, "tablet") || u.OS == "Android" && !strings.Contains(ua, "Mobile"):
+		u.Device = "tablet"
+	case strings.Contains(ua, "Mobile") || strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPod") || u.OS == "Android":
+		u.Device = "mobile"
+	default:
+		u.Device = "desktop"
+	}
+	return
+}
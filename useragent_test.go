@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestParseUserAgent(t *testing.T) {
+	cases := []struct {
+		ua string
+		u  UserAgent
+	}{
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/86.0.4240.183 Safari/537.36",
+			UserAgent{Browser: "Chrome", BrowserVersion: "86.0.4240.183", OS: "Windows", OSVersion: "10", Device: "desktop"},
+		},
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/86.0.4240.198 Safari/537.36 Edg/86.0.622.69",
+			UserAgent{Browser: "Edge", BrowserVersion: "86.0.622.69", OS: "Windows", OSVersion: "10", Device: "desktop"},
+		},
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.0.1 Safari/605.1.15",
+			UserAgent{Browser: "Safari", BrowserVersion: "14.0.1", OS: "macOS", OSVersion: "10.15.7", Device: "desktop"},
+		},
+		{
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 14_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 MicroMessenger/7.0.18(0x17001229) NetType/WIFI Language/zh_CN",
+			UserAgent{Browser: "WeChat", BrowserVersion: "7.0.18", OS: "iOS", OSVersion: "14.2", Device: "mobile"},
+		},
+		{
+			"Mozilla/5.0 (iPad; CPU OS 13_3 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/87.0.4280.77 Mobile/15E148 Safari/604.1",
+			UserAgent{Browser: "Chrome", BrowserVersion: "87.0.4280.77", OS: "iOS", OSVersion: "13.3", Device: "tablet"},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 10; SM-G975F) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/13.0 Chrome/83.0.4103.106 Mobile Safari/537.36",
+			UserAgent{Browser: "Samsung Internet", BrowserVersion: "13.0", OS: "Android", OSVersion: "10", Device: "mobile"},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 9; SM-T820) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/86.0.4240.110 Safari/537.36",
+			UserAgent{Browser: "Chrome", BrowserVersion: "86.0.4240.110", OS: "Android", OSVersion: "9", Device: "tablet"},
+		},
+		{
+			"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:82.0) Gecko/20100101 Firefox/82.0",
+			UserAgent{Browser: "Firefox", BrowserVersion: "82.0", OS: "Linux", Device: "desktop"},
+		},
+		{
+			"Mozilla/5.0 (Windows NT 6.1; WOW64; Trident/7.0; rv:11.0) like Gecko",
+			UserAgent{Browser: "IE", BrowserVersion: "11.0", OS: "Windows", OSVersion: "7", Device: "desktop"},
+		},
+		{
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			UserAgent{Browser: "Googlebot", BrowserVersion: "2.1", Device: "bot"},
+		},
+		{
+			"curl/7.64.1",
+			UserAgent{Browser: "curl", BrowserVersion: "7.64.1", Device: "bot"},
+		},
+		{"", UserAgent{}},
+		{"( bot )", UserAgent{Browser: "bot", Device: "bot"}},
+	}
+	for _, c := range cases {
+		if u := parseUserAgent(c.ua); u != c.u {
+			t.Errorf("%s\n got %+v\nwant %+v", c.ua, u, c.u)
+		}
+	}
+}
@@ -1,38 +1,22 @@
 package main
 
 import (
+	"path"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/yankeguo/byteline"
 )
 
-func decodeBeatMessage(raw string, isJSON bool, r *Record) (noOffset bool, ok bool) {
-	var yyyy, MM, dd, hh, mm, ss, SSS int64
-	buf := []byte(raw)
-	if buf, _, ok = byteline.Run(
-		buf,
-		byteline.TrimOperation{Left: true, Right: true},
-		byteline.RuneOperation{Remove: true, Allowed: []rune{'['}},
-		byteline.NumberOperation{Remove: true, Len: 4, Base: 10, Out: &yyyy},
-		byteline.RuneOperation{Remove: true, Allowed: []rune{'-', '/'}},
-		byteline.NumberOperation{Remove: true, Len: 2, Base: 10, Out: &MM},
-		byteline.RuneOperation{Remove: true, Allowed: []rune{'-', '/'}},
-		byteline.NumberOperation{Remove: true, Len: 2, Base: 10, Out: &dd},
-		byteline.RuneOperation{Remove: true, Allowed: []rune{' ', '\t'}},
-		byteline.NumberOperation{Remove: true, Len: 2, Base: 10, Out: &hh},
-		byteline.RuneOperation{Remove: true, Allowed: []rune{':'}},
-		byteline.NumberOperation{Remove: true, Len: 2, Base: 10, Out: &mm},
-		byteline.RuneOperation{Remove: true, Allowed: []rune{':'}},
-		byteline.NumberOperation{Remove: true, Len: 2, Base: 10, Out: &ss},
-		byteline.RuneOperation{Remove: true, Allowed: []rune{'.'}},
-		byteline.NumberOperation{Remove: true, Len: 3, Base: 10, Out: &SSS},
-		byteline.RuneOperation{Remove: true, Allowed: []rune{']'}},
-	); !ok {
+func decodeBeatMessage(raw string, layouts []string, isJSON bool, kv *LogfmtOptions, r *Record) (noOffset bool, reason string, ok bool) {
+	var buf []byte
+	// extract the timestamp, timestamp with zone information needs no offset
+	if r.Timestamp, buf, noOffset, ok = parseTimestamp([]byte(raw), layouts); !ok {
+		reason = ReasonBadTimestamp
 		return
 	}
-	// extract the timestamp
-	r.Timestamp = time.Date(int(yyyy), time.Month(MM), int(dd), int(hh), int(mm), int(ss), int(int64(time.Millisecond)*SSS), time.UTC)
 	// extract extra or CRID/K
 	if isJSON {
 		if buf, _, ok = byteline.Run(
@@ -40,10 +24,12 @@ func decodeBeatMessage(raw string, isJSON bool, r *Record) (noOffset bool, ok bo
 			byteline.TrimOperation{Left: true, Right: true},
 			byteline.JSONDecodeOperation{Remove: true, Out: &r.Extra},
 		); !ok {
+			reason = ReasonBadExtra
 			return
 		}
 		// topic must exist
 		if !decodeExtraStr(r.Extra, "topic", &r.Topic) {
+			reason = ReasonMissingTopic
 			ok = false
 			return
 		}
@@ -70,14 +56,64 @@ func decodeBeatMessage(raw string, isJSON bool, r *Record) (noOffset bool, ok bo
 		}
 		// assign the remaining message
 		r.Message = string(buf)
+		// extract key=value pairs from the remaining message, pairs colliding with existing extra fields stay in message
+		if kv != nil {
+			exists := func(key string) bool {
+				_, found := r.Extra[key]
+				return found
+			}
+			if pairs, rest := parseLogfmt(r.Message, kv.ConvertNumbers, exists); len(pairs) > 0 {
+				copyExtra(r)
+				for k, v := range pairs {
+					r.Extra[k] = v
+				}
+				if !kv.KeepMessage {
+					r.Message = rest
+				}
+			}
+		}
+	}
+	return
+}
+
+// resolveLogfmt find the first logfmt options matching topic and project
+func resolveLogfmt(topic, project string) (opts LogfmtOptions, ok bool) {
+	for _, opts = range options.Logfmt {
+		if matchTopicProject(opts.Topic, opts.Project, topic, project) {
+			ok = true
+			return
+		}
 	}
+	opts = LogfmtOptions{}
 	return
 }
 
+// matchTopicProject check topic and project against glob patterns, empty pattern matches anything
+func matchTopicProject(topicGlob, projectGlob, topic, project string) bool {
+	if len(topicGlob) > 0 {
+		if ok, _ := path.Match(topicGlob, topic); !ok {
+			return false
+		}
+	}
+	if len(projectGlob) > 0 {
+		if ok, _ := path.Match(projectGlob, project); !ok {
+			return false
+		}
+	}
+	return true
+}
+
 func decodeBeatSource(raw string, r *Record) bool {
 	var cs []string
 	// trim source
 	raw = strings.TrimSpace(raw)
+	// try source patterns in order
+	for _, s := range options.Sources {
+		if s.Decode(raw, r) {
+			return true
+		}
+	}
+	// fallback to '.../env/topic/project.xxx.log'
 	if cs = strings.Split(raw, "/"); len(cs) < 3 {
 		return false
 	}
@@ -122,6 +158,121 @@ func decodeExtraTime(m map[string]interface{}, key string, out *time.Time) bool
 	return false
 }
 
+func assignExtraIfAbsent(r *Record, key string, val interface{}) {
+	if r.Extra == nil {
+		r.Extra = map[string]interface{}{}
+	}
+	if _, found := r.Extra[key]; !found {
+		r.Extra[key] = val
+	}
+}
+
+// isLogfmtKey check key only contains letters, digits, '_', '.' and '-'
+func isLogfmtKey(key string) bool {
+	if len(key) == 0 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLogfmt parse key=value pairs, values can be double quoted, rest is s with pairs and the spaces before them cut out,
+// numeric values are converted to int64 or float64 if convert is true, pairs whose key is repeated or reported by exists
+// are left in rest
+func parseLogfmt(s string, convert bool, exists func(key string) bool) (pairs map[string]interface{}, rest string) {
+	// s[kept:] is not written to sb yet
+	var sb strings.Builder
+	var kept int
+	defer func() {
+		sb.WriteString(s[kept:])
+		rest = strings.TrimLeft(sb.String(), " \t")
+	}()
+	for i := 0; i < len(s); {
+		// skip spaces
+		if s[i] == ' ' || s[i] == '\t' {
+			i++
+			continue
+		}
+		// read key
+		start := i
+		for i < len(s) && s[i] != '=' && s[i] != ' ' && s[i] != '\t' {
+			i++
+		}
+		key, cut := s[start:i], start
+		if i >= len(s) || s[i] != '=' || !isLogfmtKey(key) {
+			// skip the word
+			for i < len(s) && s[i] != ' ' && s[i] != '\t' {
+				i++
+			}
+			continue
+		}
+		i++
+		// read value
+		var val string
+		if i < len(s) && s[i] == '"' {
+			start = i
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+			if i < len(s) {
+				i++
+			} else {
+				// unterminated, possibly after a trailing escape
+				i = len(s)
+			}
+			var err error
+			if val, err = strconv.Unquote(s[start:i]); err != nil {
+				val = strings.Trim(s[start:i], `"`)
+			}
+		} else {
+			start = i
+			for i < len(s) && s[i] != ' ' && s[i] != '\t' {
+				i++
+			}
+			val = s[start:i]
+		}
+		if _, found := pairs[key]; found || (exists != nil && exists(key)) {
+			continue
+		}
+		// cut the pair and the spaces before it
+		for cut > kept && (s[cut-1] == ' ' || s[cut-1] == '\t') {
+			cut--
+		}
+		sb.WriteString(s[kept:cut])
+		kept = i
+		if pairs == nil {
+			pairs = map[string]interface{}{}
+		}
+		pairs[key] = val
+		if convert {
+			if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+				pairs[key] = n
+			} else if f, err := strconv.ParseFloat(val, 64); err == nil {
+				pairs[key] = f
+			}
+		}
+	}
+	return
+}
+
+// truncateUTF8 truncate string to at most max bytes without breaking UTF-8 sequences
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
 func stringSliceContainsIgnoreCase(s []string, t string) bool {
 	for _, r := range s {
 		if strings.ToLower(r) == strings.ToLower(t) {
@@ -1 +1,50 @@
 package main
+
+import "testing"
+
+func TestParseLogfmt(t *testing.T) {
+	pairs, rest := parseLogfmt(`done a=1 b="x y" c= broken=" tail`, true, nil)
+	if rest != "done" {
+		t.Fatal("rest", rest)
+	}
+	if pairs["a"] != int64(1) || pairs["b"] != "x y" || pairs["c"] != "" || pairs["broken"] != " tail" {
+		t.Fatal("pairs", pairs)
+	}
+	if pairs, rest = parseLogfmt("plain text", false, nil); pairs != nil || rest != "plain text" {
+		t.Fatal("plain", pairs, rest)
+	}
+	if _, rest = parseLogfmt("a=1 first  line\n\tsecond b=2   third", false, nil); rest != "first  line\n\tsecond   third" {
+		t.Fatalf("whitespace %q", rest)
+	}
+	if pairs, _ = parseLogfmt(`k="trailing\`, false, nil); pairs["k"] != `trailing\` {
+		t.Fatal("trailing escape", pairs)
+	}
+	// only identifier keys
+	if pairs, rest = parseLogfmt("GET /search?q=1 status=200", false, nil); len(pairs) != 1 || pairs["status"] != "200" || rest != "GET /search?q=1" {
+		t.Fatal("keys", pairs, rest)
+	}
+	// repeated and existing keys stay in rest
+	exists := func(key string) bool { return key == "service" }
+	if pairs, rest = parseLogfmt("a=1 service=x a=2 b=3", false, exists); len(pairs) != 2 || pairs["a"] != "1" || rest != "service=x a=2" {
+		t.Fatal("collision", pairs, rest)
+	}
+}
+
+func TestMatchTopicProject(t *testing.T) {
+	cases := []struct {
+		topicGlob, projectGlob, topic, project string
+		ok                                     bool
+	}{
+		{"", "", "info", "web", true},
+		{"info", "", "info", "web", true},
+		{"err*", "", "info", "web", false},
+		{"", "web-*", "info", "web-api", true},
+		{"info", "web-*", "info", "api", false},
+		{"[", "", "info", "web", false},
+	}
+	for _, c := range cases {
+		if ok := matchTopicProject(c.topicGlob, c.projectGlob, c.topic, c.project); ok != c.ok {
+			t.Fatal(c, ok)
+		}
+	}
+}